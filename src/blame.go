@@ -0,0 +1,245 @@
+package main
+
+import (
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	elements "openplc-render/elements"
+	svg "openplc-render/svg"
+)
+
+// Commits that last changed every element and connection of a POU
+type blameResult struct {
+	Elements    map[string]commitInfo // By element UID
+	Connections map[string]commitInfo // By elements.ConnectionID
+	Commits     []commitInfo          // All commits that got attributed something, oldest first
+}
+
+func runBlame(args []string) error {
+	flags := flag.NewFlagSet("blame", flag.ExitOnError)
+	filePath := flags.String("file", "", "Path to file inside the git repo")
+	pouName := flags.String("pou", "", "Which POU to render")
+	ref := flags.String("ref", "HEAD", "Version of the POU to annotate")
+	outputFolder := flags.String("output", "", "Folder for the output .svg file, will put it in a system temporary folder otherwise")
+	style := flags.String("style", "dark", "Diagram style, name of a built-in or loaded theme, dark by default")
+	themeFile := flags.String("theme", "", "JSON, YAML or TOML file with a custom theme, used instead of --style")
+	flags.Parse(args)
+
+	if *filePath == "" {
+		return fmt.Errorf("error: file path not provided")
+	}
+	if *pouName == "" {
+		return fmt.Errorf("error: pou name not provided")
+	}
+	if err := loadThemeFile(*themeFile, style); err != nil {
+		return err
+	}
+	if err := checkStyle(*style); err != nil {
+		return err
+	}
+	folder, err := prepareOutputFolder(*outputFolder)
+	if err != nil {
+		return err
+	}
+	log.Printf("output folder path: %s", folder)
+
+	absPath, err := filepath.Abs(*filePath)
+	if err != nil {
+		return err
+	}
+	repoPath, err := getRepoRoot(absPath)
+	if err != nil {
+		return err
+	}
+	relPath, err := filepath.Rel(repoPath, absPath)
+	if err != nil {
+		return err
+	}
+	commits, err := getFileHistory(repoPath, filepath.ToSlash(relPath), "", *ref)
+	if err != nil {
+		return err
+	}
+	if len(commits) == 0 {
+		return fmt.Errorf("no commits touching %s up to %s", relPath, *ref)
+	}
+	current, found, err := loadPOUAtCommit(repoPath, *pouName, commits[len(commits)-1])
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("no POU with name %s available at %s", *pouName, *ref)
+	}
+	blame, err := blamePOU(repoPath, *pouName, &current, commits)
+	if err != nil {
+		return err
+	}
+	file := svg.RenderAnnotatedPOU(current, *style, blameAnnotations(blame))
+	path := filepath.Join(folder, "blame.svg")
+	content, err := xml.MarshalIndent(file, " ", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		return err
+	}
+	log.Printf("blame written to %s", path)
+	return openOutputFolder(folder)
+}
+
+// Walks back through the history and attributes every element and connection of the
+// current POU to the newest commit that changed it. Commits are ordered oldest first,
+// the last one being the version of the current POU.
+func blamePOU(repoPath, pouName string, current *elements.POU, commits []commitInfo) (blameResult, error) {
+	result := blameResult{
+		Elements:    make(map[string]commitInfo),
+		Connections: make(map[string]commitInfo),
+	}
+	pending := len(current.Elements) + countConnections(current)
+	for i := len(commits) - 1; i > 0 && pending > 0; i-- {
+		older, newer := commits[i-1], commits[i]
+		oldPou, _, err := loadPOUAtCommit(repoPath, pouName, older)
+		if err != nil {
+			return result, err
+		}
+		newPou, _, err := loadPOUAtCommit(repoPath, pouName, newer)
+		if err != nil {
+			return result, err
+		}
+		oldPou.CalculateDiff(&newPou)
+		pending -= attributeChanges(current, &newPou, newer, result)
+	}
+	// Whatever didn't change since is as old as the file itself
+	if pending > 0 {
+		attributeAll(current, commits[0], result)
+	}
+	result.Commits = attributedCommits(commits, result)
+	return result, nil
+}
+
+// Attributes whatever of the current POU is still unattributed and was changed in
+// the diffed POU to the given commit, returns the number of new attributions
+func attributeChanges(current, diffed *elements.POU, commit commitInfo, result blameResult) int {
+	attributed := 0
+	for uid, elem := range current.Elements {
+		changed, ok := diffed.Elements[uid]
+		if !ok {
+			continue
+		}
+		if _, done := result.Elements[uid]; !done && changed.HasFieldChanges() {
+			result.Elements[uid] = commit
+			attributed++
+		}
+		// Connections that are new in the diffed version were introduced by the commit
+		added := make(map[string]bool)
+		forEachConnection(changed, func(id string, conn *elements.Connection) {
+			if conn.Diff == elements.DiffAdded {
+				added[id] = true
+			}
+		})
+		forEachConnection(elem, func(id string, conn *elements.Connection) {
+			if _, done := result.Connections[id]; !done && added[id] {
+				result.Connections[id] = commit
+				attributed++
+			}
+		})
+	}
+	return attributed
+}
+
+func attributeAll(current *elements.POU, commit commitInfo, result blameResult) {
+	for uid, elem := range current.Elements {
+		if _, done := result.Elements[uid]; !done {
+			result.Elements[uid] = commit
+		}
+		forEachConnection(elem, func(id string, conn *elements.Connection) {
+			if _, done := result.Connections[id]; !done {
+				result.Connections[id] = commit
+			}
+		})
+	}
+}
+
+func forEachConnection(elem *elements.Element, fn func(id string, conn *elements.Connection)) {
+	for _, pin := range elem.Inputs {
+		for _, conn := range pin.Connections {
+			fn(elements.ConnectionID(elem.UID, true, pin.Order, conn), conn)
+		}
+	}
+	for _, pin := range elem.Outputs {
+		for _, conn := range pin.Connections {
+			fn(elements.ConnectionID(elem.UID, false, pin.Order, conn), conn)
+		}
+	}
+}
+
+func countConnections(pou *elements.POU) int {
+	count := 0
+	for _, elem := range pou.Elements {
+		forEachConnection(elem, func(string, *elements.Connection) { count++ })
+	}
+	return count
+}
+
+// Keeps only the commits something was attributed to, preserving their order
+func attributedCommits(commits []commitInfo, result blameResult) []commitInfo {
+	used := make(map[string]bool)
+	for _, commit := range result.Elements {
+		used[commit.SHA] = true
+	}
+	for _, commit := range result.Connections {
+		used[commit.SHA] = true
+	}
+	var attributed []commitInfo
+	for _, commit := range commits {
+		if used[commit.SHA] {
+			attributed = append(attributed, commit)
+			delete(used, commit.SHA)
+		}
+	}
+	return attributed
+}
+
+// Color on a blue (oldest) to red (newest) scale
+func heatColor(index, count int) string {
+	hue := 240
+	if count > 1 {
+		hue = 240 - 240*index/(count-1)
+	}
+	return fmt.Sprintf("hsl(%d, 80%%, 50%%)", hue)
+}
+
+func blameTitle(commit commitInfo) string {
+	return fmt.Sprintf("%s %s <%s>, %s: %s", commit.ShortSHA(), commit.Author, commit.Email, commit.Date, commit.Subject)
+}
+
+// Tooltips with the last change for everything, and an age heatmap behind elements
+func blameAnnotations(blame blameResult) svg.Annotations {
+	colors := make(map[string]string)
+	annotations := svg.Annotations{
+		Elements:    make(map[string]svg.Annotation),
+		Connections: make(map[string]svg.Annotation),
+	}
+	for i, commit := range blame.Commits {
+		colors[commit.SHA] = heatColor(i, len(blame.Commits))
+		annotations.Legend = append(annotations.Legend, svg.LegendEntry{
+			Color: colors[commit.SHA],
+			Label: blameTitle(commit),
+		})
+	}
+	for uid, commit := range blame.Elements {
+		annotations.Elements[uid] = svg.Annotation{
+			Title:     blameTitle(commit),
+			Highlight: colors[commit.SHA],
+		}
+	}
+	for id, commit := range blame.Connections {
+		annotations.Connections[id] = svg.Annotation{
+			Title: blameTitle(commit),
+		}
+	}
+	return annotations
+}
@@ -0,0 +1,94 @@
+package elements
+
+// Folds variable boxes wired to nothing but a single block pin into that pin, as
+// its argument, and drops them along with their wires. Blocks whose arguments
+// changed are marked modified. Rungs keep their numbers, but shrink to what's
+// left of them. The original POU is left untouched.
+func (p *POU) Compact() POU {
+	compact := POU{
+		Name:     p.Name,
+		Type:     p.Type,
+		Comment:  p.Comment,
+		Elements: make(map[string]*Element),
+	}
+	for uid, elem := range p.Elements {
+		compact.Elements[uid] = elem.Copy()
+	}
+	// How many wires every element feeds
+	readers := make(map[string]int)
+	for _, elem := range p.Elements {
+		for _, pins := range [][]*Pin{elem.Inputs, elem.Outputs} {
+			for _, pin := range pins {
+				for _, conn := range pin.Connections {
+					readers[conn.TargetRef]++
+				}
+			}
+		}
+	}
+	folded := make(map[string]bool)
+	fold := func(block *Element, pin *Pin, variable *Element, conn *Connection) {
+		pin.Argument = variable.ElementText
+		if pin.Argument.Diff == DiffUnchanged {
+			pin.Argument.Diff = conn.Diff
+		}
+		if pin.Argument.Diff != DiffUnchanged && block.Diff == DiffUnchanged {
+			block.Diff = DiffModified
+		}
+		folded[variable.UID] = true
+		delete(compact.Elements, variable.UID)
+	}
+	for _, block := range compact.Elements {
+		if block.Type != "block" {
+			continue
+		}
+		// Inputs fed by a variable or literal that doesn't feed anything else
+		for _, pin := range block.Inputs {
+			if len(pin.Connections) != 1 {
+				continue
+			}
+			conn := pin.Connections[0]
+			variable, ok := compact.Elements[conn.TargetRef]
+			if ok && variable.Type == "inVariable" && readers[variable.UID] == 1 {
+				fold(block, pin, variable, conn)
+				pin.Connections = nil
+			}
+		}
+	}
+	// Outputs assigned to a variable that's wired to nothing else
+	for uid, variable := range compact.Elements {
+		if variable.Type != "outVariable" || readers[uid] > 0 || len(variable.Inputs) != 1 || len(variable.Inputs[0].Connections) != 1 {
+			continue
+		}
+		conn := variable.Inputs[0].Connections[0]
+		block, ok := compact.Elements[conn.TargetRef]
+		if !ok || block.Type != "block" {
+			continue
+		}
+		for _, pin := range block.Outputs {
+			if pin.Label.Value == conn.TargetLabel && pin.Argument.Value == "" {
+				fold(block, pin, variable, conn)
+				break
+			}
+		}
+	}
+	compact.rungIndex = make(map[string]*Rung)
+	for _, rung := range p.Rungs {
+		compact_rung := &Rung{Number: rung.Number}
+		for _, uid := range rung.Elements {
+			if folded[uid] {
+				continue
+			}
+			elem := compact.Elements[uid]
+			if len(compact_rung.Elements) == 0 {
+				compact_rung.Top, compact_rung.Bottom = elem.Position.Y, elem.Position.Y
+			}
+			compact_rung.Elements = append(compact_rung.Elements, uid)
+			compact_rung.extend(elem)
+			compact.rungIndex[uid] = compact_rung
+		}
+		if len(compact_rung.Elements) > 0 {
+			compact.Rungs = append(compact.Rungs, compact_rung)
+		}
+	}
+	return compact
+}
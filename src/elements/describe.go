@@ -0,0 +1,114 @@
+package elements
+
+import "strings"
+
+func (d Diff) String() string {
+	switch d {
+	case DiffDeleted:
+		return "deleted"
+	case DiffAdded:
+		return "added"
+	case DiffModified:
+		return "modified"
+	}
+	return "unchanged"
+}
+
+var element_kinds = map[string]string{
+	"connector":      "Connector",
+	"continuation":   "Continuation",
+	"inVariable":     "Input variable",
+	"outVariable":    "Output variable",
+	"inOutVariable":  "In-out variable",
+	"leftPowerRail":  "Left power rail",
+	"rightPowerRail": "Right power rail",
+}
+
+// What the element is in words, like "Normally closed contact" or "TON block"
+func (e *Element) Kind() string {
+	switch e.Type {
+	case "contact", "coil":
+		return e.modifiedKind()
+	case "block":
+		return e.BlockLabel.Value + " block"
+	}
+	if kind, ok := element_kinds[e.Type]; ok {
+		return kind
+	}
+	return e.Type
+}
+
+var modifier_words = map[string]string{
+	"/": "negated",
+	"P": "rising edge",
+	"N": "falling edge",
+	"S": "set",
+	"R": "reset",
+}
+
+// Contacts and coils are named after their modifiers, like "Negated rising edge
+// coil". Plain and negated contacts have names of their own.
+func (e *Element) modifiedKind() string {
+	var words []string
+	switch {
+	case e.Type == "contact" && e.Negated.Value != "":
+		words = append(words, "normally closed")
+	case e.Type == "contact" && e.Edge.Value == "":
+		words = append(words, "normally open")
+	case e.Negated.Value != "":
+		words = append(words, modifier_words[e.Negated.Value])
+	}
+	for _, modifier := range []string{e.Storage.Value, e.Edge.Value} {
+		if modifier != "" {
+			words = append(words, modifier_words[modifier])
+		}
+	}
+	words = append(words, e.Type)
+	kind := strings.Join(words, " ")
+	return strings.ToUpper(kind[:1]) + kind[1:]
+}
+
+// Kind and name of the element, like "Normally closed contact stop"
+func (e *Element) Describe() string {
+	name := e.TopLabel.Value
+	switch e.Type {
+	case "connector", "continuation", "inVariable", "outVariable", "inOutVariable":
+		name = e.ElementText.Value
+	}
+	if name == "" {
+		return e.Kind()
+	}
+	return e.Kind() + " " + name
+}
+
+// Names of the labels that changed, only meaningful after CalculateDiff
+func (e *Element) ChangedLabels() []string {
+	var changed []string
+	for _, label := range []struct {
+		name  string
+		label MutableString
+	}{
+		{"label", e.TopLabel},
+		{"text", e.ElementText},
+		{"type", e.BlockLabel},
+		{"execution order", e.ExecutionOrder},
+		{"negation", e.Negated},
+		{"edge", e.Edge},
+		{"storage", e.Storage},
+	} {
+		if label.label.Diff != DiffUnchanged {
+			changed = append(changed, label.name)
+		}
+	}
+	for _, pins := range [][]*Pin{e.Inputs, e.Outputs} {
+		for _, pin := range pins {
+			if pin.Label.Diff != DiffUnchanged && pin.Label.Value != "" {
+				changed = append(changed, "pin "+pin.Label.Value)
+			}
+			if pin.Argument.Diff != DiffUnchanged {
+				changed = append(changed, "argument of "+pin.Label.Value)
+			}
+		}
+	}
+	return changed
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	plcxml "openplc-render/xml"
 	"os"
+	"strconv"
 )
 
 // Consts
@@ -16,6 +17,7 @@ const (
 	DiffUnchanged Diff = iota
 	DiffDeleted
 	DiffAdded
+	DiffModified // The element exists in both versions, but some of its labels changed
 )
 
 // Used to represent fields that can have a diff
@@ -46,34 +48,45 @@ type Pin struct {
 	Position    Position // Topographical coordinates
 	Order       int      // In the order of inputs/outputs, i.e 1st, 2nd pin and so on
 	Label       MutableString
+	Argument    MutableString // Expression of the variable folded into the pin, see Compact
 	Connections []*Connection
 }
 
 type Element struct {
-	UID         string
-	Type        string
-	Position    Position
-	Width       int
-	Height      int
-	ElementText MutableString // Like a slash for a negated contact, for example
-	TopLabel    MutableString
-	BottomLabel MutableString
-	BlockLabel  MutableString
-	Inputs      []*Pin
-	Outputs     []*Pin
-	Diff        Diff
+	UID            string
+	Type           string
+	Position       Position
+	Width          int
+	Height         int
+	ElementText    MutableString // Like a slash for a negated contact, for example
+	Negated        MutableString // "/" if negated, contacts and coils only
+	Edge           MutableString // "P" for rising, "N" for falling edge, contacts and coils only
+	Storage        MutableString // "S" for set, "R" for reset, coils only
+	TopLabel       MutableString
+	BottomLabel    MutableString
+	BlockLabel     MutableString
+	ExecutionOrder MutableString // Position in the order the POU body is evaluated in, empty if not set
+	Inputs         []*Pin
+	Outputs        []*Pin
+	Diff           Diff
 }
 
 type POU struct {
-	Name     string
-	Comment  string
-	Elements map[string]*Element
+	Name      string
+	Type      string // program, functionBlock or function
+	Comment   string
+	Elements  map[string]*Element
+	Rungs     []*Rung
+	rungIndex map[string]*Rung // Rung of every element by UID
 }
 
 // TODO: Add description and comment processing
 func (p *POU) Parse(pou plcxml.POU) error {
 	p.Name = pou.Name
+	p.Type = pou.POUType
 	p.parseElements(pou)
+	p.resolveWires()
+	p.detectRungs()
 	return nil
 }
 
@@ -109,33 +122,9 @@ func (p *POU) parseElements(pou plcxml.POU) error {
 func getPrimitiveText(prim plcxml.Primitive) MutableString {
 	text := ""
 	switch prim.ElemType {
-	case "contact":
-		if prim.Negated {
-			text = "/"
-			break
-		}
-		if prim.Edge == "rising" {
-			text = "P"
-		}
-		if prim.Edge == "falling" {
-			text = "N"
-		}
-	case "coil":
-		if prim.Negated {
-			text = "/"
-		}
-		if prim.Edge == "rising" {
-			text = "P"
-		}
-		if prim.Storage == "set" {
-			text = "S"
-		}
-		if prim.Storage == "reset" {
-			text = "R"
-		}
-		if prim.Edge == "falling" {
-			text = "N"
-		}
+	case "contact", "coil":
+		negated, edge, storage := getModifiers(prim)
+		text = negated.Value + storage.Value + edge.Value
 	case "connector", "continuation":
 		text = prim.Name
 	case "inOutVariable", "inVariable", "outVariable":
@@ -146,6 +135,37 @@ func getPrimitiveText(prim plcxml.Primitive) MutableString {
 	}
 }
 
+// IEC 61131-3 symbols of the modifiers, each one on its own so that they can be
+// combined and diffed separately
+func getModifiers(prim plcxml.Primitive) (negated, edge, storage MutableString) {
+	if prim.Negated {
+		negated.Value = "/"
+	}
+	switch prim.Edge {
+	case "rising":
+		edge.Value = "P"
+	case "falling":
+		edge.Value = "N"
+	}
+	switch prim.Storage {
+	case "set":
+		storage.Value = "S"
+	case "reset":
+		storage.Value = "R"
+	}
+	return negated, edge, storage
+}
+
+// Zero is what editors write for elements that aren't ordered explicitly
+func getExecutionOrder(id int) MutableString {
+	if id == 0 {
+		return MutableString{}
+	}
+	return MutableString{
+		Value: strconv.Itoa(id),
+	}
+}
+
 func initPrimitiveFromXML(prim plcxml.Primitive) (*Element, error) {
 	new_prim := Element{}
 	// Process basic fields
@@ -155,6 +175,10 @@ func initPrimitiveFromXML(prim plcxml.Primitive) (*Element, error) {
 	new_prim.Width = prim.Width
 	new_prim.Height = prim.Height
 	new_prim.ElementText = getPrimitiveText(prim)
+	if new_prim.HasModifiers() {
+		new_prim.Negated, new_prim.Edge, new_prim.Storage = getModifiers(prim)
+	}
+	new_prim.ExecutionOrder = getExecutionOrder(prim.ExecutionOrderId)
 	if prim.Variable != "" {
 		new_prim.TopLabel = MutableString{
 			Value: prim.Variable,
@@ -232,6 +256,7 @@ func initBlockFromXML(block plcxml.Block) (*Element, error) {
 	new_block.BlockLabel = MutableString{
 		Value: block.TypeName,
 	}
+	new_block.ExecutionOrder = getExecutionOrder(block.ExecutionOrderId)
 	// Handle inputs
 	for pin_index, variable := range block.InputVariables.Variable {
 		pin_position := Position(variable.ConnectionPointIn[0].RelPosition)
@@ -298,6 +323,11 @@ func initBlockFromXML(block plcxml.Block) (*Element, error) {
 	return &new_block, nil
 }
 
+// Contacts and coils, which carry negation, edge and storage modifiers
+func (e *Element) HasModifiers() bool {
+	return e.Type == "contact" || e.Type == "coil"
+}
+
 // Diffing logic
 
 func (p *POU) CalculateDiff(new_pou *POU) {
@@ -317,7 +347,10 @@ outer:
 							if elem.TopLabel.Value != elem2.TopLabel.Value {
 								elem.TopLabel.Diff = DiffDeleted
 								elem2.TopLabel.Diff = DiffAdded
+								elem.Diff = DiffModified
+								elem2.Diff = DiffModified
 							}
+							elem.executionOrderDiff(elem2)
 							elem.connectionsDiff(elem2)
 							continue outer
 						} else {
@@ -332,12 +365,20 @@ outer:
 				if elem.TopLabel.Value != elem2.TopLabel.Value {
 					elem.TopLabel.Diff = DiffDeleted
 					elem2.TopLabel.Diff = DiffAdded
+					elem.Diff = DiffModified
+					elem2.Diff = DiffModified
 				}
-				// Primitive type may be the same (contact, for example), but the subtype could be negated, for example, affecting the element text
-				if elem.ElementText.Value != elem2.ElementText.Value {
+				// Primitive type may be the same (contact, for example), but the subtype could be negated, for example, affecting the element text.
+				// Modifiers are diffed one by one, the element text is just their combination then.
+				if elem.HasModifiers() {
+					elem.modifiersDiff(elem2)
+				} else if elem.ElementText.Value != elem2.ElementText.Value {
 					elem.ElementText.Diff = DiffDeleted
 					elem2.ElementText.Diff = DiffAdded
+					elem.Diff = DiffModified
+					elem2.Diff = DiffModified
 				}
+				elem.executionOrderDiff(elem2)
 				// Layer 2: diff connections
 				elem.connectionsDiff(elem2)
 				continue outer
@@ -365,6 +406,32 @@ outer_back:
 	}
 }
 
+// A changed execution order is a change even when the drawing looks the same
+func (e *Element) executionOrderDiff(new_elem *Element) {
+	if e.ExecutionOrder.Value != new_elem.ExecutionOrder.Value {
+		e.ExecutionOrder.Diff = DiffDeleted
+		new_elem.ExecutionOrder.Diff = DiffAdded
+		e.Diff = DiffModified
+		new_elem.Diff = DiffModified
+	}
+}
+
+// Contacts and coils have modifiers, each of them changed counts as a change of its own
+func (e *Element) modifiersDiff(new_elem *Element) {
+	for _, modifier := range []struct{ old, new *MutableString }{
+		{&e.Negated, &new_elem.Negated},
+		{&e.Edge, &new_elem.Edge},
+		{&e.Storage, &new_elem.Storage},
+	} {
+		if modifier.old.Value != modifier.new.Value {
+			modifier.old.Diff = DiffDeleted
+			modifier.new.Diff = DiffAdded
+			e.Diff = DiffModified
+			new_elem.Diff = DiffModified
+		}
+	}
+}
+
 func (e *Element) markAllConnectionsDeleted() {
 	for _, pin := range e.Inputs {
 		pin.Label.Diff = DiffDeleted
@@ -400,6 +467,10 @@ func (e *Element) markAllLabelsDeleted() {
 	e.TopLabel.Diff = DiffDeleted
 	e.BottomLabel.Diff = DiffDeleted
 	e.BlockLabel.Diff = DiffDeleted
+	e.ExecutionOrder.Diff = DiffDeleted
+	e.Negated.Diff = DiffDeleted
+	e.Edge.Diff = DiffDeleted
+	e.Storage.Diff = DiffDeleted
 }
 
 func (e *Element) markAllLabelsAdded() {
@@ -407,6 +478,10 @@ func (e *Element) markAllLabelsAdded() {
 	e.TopLabel.Diff = DiffAdded
 	e.BottomLabel.Diff = DiffAdded
 	e.BlockLabel.Diff = DiffAdded
+	e.ExecutionOrder.Diff = DiffAdded
+	e.Negated.Diff = DiffAdded
+	e.Edge.Diff = DiffAdded
+	e.Storage.Diff = DiffAdded
 }
 
 // TODO: Consider possible different number of inputs and outputs in different versions
@@ -483,3 +558,167 @@ func (e *Element) connectionsDiff(new_elem *Element) {
 		}
 	}
 }
+
+// Reports whether the element itself or any of its labels carries a diff, connections aside
+func (e *Element) HasFieldChanges() bool {
+	if e.Diff != DiffUnchanged ||
+		e.ElementText.Diff != DiffUnchanged ||
+		e.TopLabel.Diff != DiffUnchanged ||
+		e.BottomLabel.Diff != DiffUnchanged ||
+		e.BlockLabel.Diff != DiffUnchanged ||
+		e.ExecutionOrder.Diff != DiffUnchanged ||
+		e.Negated.Diff != DiffUnchanged ||
+		e.Edge.Diff != DiffUnchanged ||
+		e.Storage.Diff != DiffUnchanged {
+		return true
+	}
+	for _, pins := range [][]*Pin{e.Inputs, e.Outputs} {
+		for _, pin := range pins {
+			if pin.Label.Diff != DiffUnchanged || pin.Argument.Diff != DiffUnchanged {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Reports whether any field or connection of the element carries a diff
+func (e *Element) HasChanges() bool {
+	if e.HasFieldChanges() {
+		return true
+	}
+	for _, pins := range [][]*Pin{e.Inputs, e.Outputs} {
+		for _, pin := range pins {
+			for _, conn := range pin.Connections {
+				if conn.Diff != DiffUnchanged {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// Stable identifier of a connection: the element and pin it starts from and the element
+// and pin it goes to. Unlike the wire coordinates, it survives moving things around.
+func ConnectionID(elemUID string, input bool, pinOrder int, conn *Connection) string {
+	direction := "out"
+	if input {
+		direction = "in"
+	}
+	return fmt.Sprintf("%s/%s/%d->%s/%s", elemUID, direction, pinOrder, conn.TargetRef, conn.TargetLabel)
+}
+
+// Reports whether any element of the POU has changed, only meaningful after CalculateDiff
+func (p *POU) HasChanges() bool {
+	for _, elem := range p.Elements {
+		if elem.HasChanges() {
+			return true
+		}
+	}
+	return false
+}
+
+// Three-way diffing logic
+
+// Which side of a three-way diff changed an element relative to the common base
+type Change int
+
+const (
+	ChangeNone     Change = iota
+	ChangeOurs            // Changed on our side only
+	ChangeTheirs          // Changed on their side only
+	ChangeBoth            // Changed on both sides in the same way
+	ChangeConflict        // Changed on both sides in different ways
+)
+
+func (c Change) String() string {
+	switch c {
+	case ChangeOurs:
+		return "ours-only"
+	case ChangeTheirs:
+		return "theirs-only"
+	case ChangeBoth:
+		return "both"
+	case ChangeConflict:
+		return "conflicting"
+	}
+	return "unchanged"
+}
+
+// Compares elements the same way CalculateDiff does, without marking anything.
+// Either of them may be nil, meaning the element doesn't exist in that version.
+func (e *Element) Equal(other *Element) bool {
+	if !e.FieldsEqual(other) {
+		return false
+	}
+	if e == nil {
+		return true
+	}
+	return pinsEqual(e.Inputs, other.Inputs) && pinsEqual(e.Outputs, other.Outputs)
+}
+
+// Same as Equal, but ignores connections
+func (e *Element) FieldsEqual(other *Element) bool {
+	if e == nil || other == nil {
+		return e == nil && other == nil
+	}
+	return e.Type == other.Type &&
+		e.ElementText.Value == other.ElementText.Value &&
+		e.TopLabel.Value == other.TopLabel.Value &&
+		e.BottomLabel.Value == other.BottomLabel.Value &&
+		e.BlockLabel.Value == other.BlockLabel.Value &&
+		e.ExecutionOrder.Value == other.ExecutionOrder.Value &&
+		e.Negated.Value == other.Negated.Value &&
+		e.Edge.Value == other.Edge.Value &&
+		e.Storage.Value == other.Storage.Value
+}
+
+func pinsEqual(pins, other []*Pin) bool {
+	if len(pins) != len(other) {
+		return false
+	}
+	for i, pin := range pins {
+		if pin.Label.Value != other[i].Label.Value || len(pin.Connections) != len(other[i].Connections) {
+			return false
+		}
+	outer:
+		for _, conn := range pin.Connections {
+			for _, conn2 := range other[i].Connections {
+				if conn.TargetRef == conn2.TargetRef && conn.TargetLabel == conn2.TargetLabel {
+					continue outer
+				}
+			}
+			return false
+		}
+	}
+	return true
+}
+
+// Classifies every element changed in ours or theirs relative to base, by UID.
+// Unchanged elements are left out.
+func ThreeWayDiff(base, ours, theirs *POU) map[string]Change {
+	changes := make(map[string]Change)
+	uids := make(map[string]bool)
+	for _, pou := range []*POU{base, ours, theirs} {
+		for uid := range pou.Elements {
+			uids[uid] = true
+		}
+	}
+	for uid := range uids {
+		baseElem, oursElem, theirsElem := base.Elements[uid], ours.Elements[uid], theirs.Elements[uid]
+		oursChanged := !baseElem.Equal(oursElem)
+		theirsChanged := !baseElem.Equal(theirsElem)
+		switch {
+		case oursChanged && theirsChanged && oursElem.Equal(theirsElem):
+			changes[uid] = ChangeBoth
+		case oursChanged && theirsChanged:
+			changes[uid] = ChangeConflict
+		case oursChanged:
+			changes[uid] = ChangeOurs
+		case theirsChanged:
+			changes[uid] = ChangeTheirs
+		}
+	}
+	return changes
+}
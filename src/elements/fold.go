@@ -0,0 +1,162 @@
+package elements
+
+import "fmt"
+
+// Vertical space kept around every rung, enough to fit labels above elements
+const foldMargin = 20
+
+// Height of the marker standing in for a run of folded rungs
+const foldHeight = 40
+
+// A run of unchanged rungs that got folded away, like a skipped hunk in a diff
+type Fold struct {
+	Y     int // Vertical position of the marker in the folded POU
+	First int // Numbers of the first and last folded rungs
+	Last  int
+}
+
+func (f Fold) String() string {
+	count := f.Last - f.First + 1
+	if count == 1 {
+		return fmt.Sprintf("… 1 unchanged rung (%d) …", f.First)
+	}
+	return fmt.Sprintf("… %d unchanged rungs (%d-%d) …", count, f.First, f.Last)
+}
+
+// Returns a deep copy of the element
+func (e *Element) Copy() *Element {
+	elem := *e
+	elem.Inputs = copyPins(e.Inputs)
+	elem.Outputs = copyPins(e.Outputs)
+	return &elem
+}
+
+func copyPins(pins []*Pin) []*Pin {
+	var copied []*Pin
+	for _, pin := range pins {
+		new_pin := *pin
+		new_pin.Connections = nil
+		for _, conn := range pin.Connections {
+			new_conn := *conn
+			new_conn.Points = nil
+			for _, point := range conn.Points {
+				new_point := *point
+				new_conn.Points = append(new_conn.Points, &new_point)
+			}
+			new_pin.Connections = append(new_pin.Connections, &new_conn)
+		}
+		copied = append(copied, &new_pin)
+	}
+	return copied
+}
+
+// Moves the element together with its wires
+func (e *Element) Translate(dx, dy int) {
+	e.Position.X += dx
+	e.Position.Y += dy
+	for _, pins := range [][]*Pin{e.Inputs, e.Outputs} {
+		for _, pin := range pins {
+			for _, conn := range pin.Connections {
+				for _, point := range conn.Points {
+					point.X += dx
+					point.Y += dy
+				}
+			}
+		}
+	}
+}
+
+// Keeps only the rungs with changes and up to context rungs around each of them,
+// replacing the rest with folds and moving everything up to close the gaps.
+// Only meaningful after CalculateDiff. The original POU is left untouched.
+func (p *POU) Fold(context int) (POU, []Fold) {
+	folded := POU{
+		Name:     p.Name,
+		Type:     p.Type,
+		Comment:  p.Comment,
+		Elements: make(map[string]*Element),
+	}
+	visible := make([]bool, len(p.Rungs))
+	for i, rung := range p.Rungs {
+		if !p.RungHasChanges(rung) {
+			continue
+		}
+		for j := i - context; j <= i+context; j++ {
+			if j >= 0 && j < len(p.Rungs) {
+				visible[j] = true
+			}
+		}
+	}
+	var folds []Fold
+	cursor := 0
+	for i := 0; i < len(p.Rungs); i++ {
+		rung := p.Rungs[i]
+		if !visible[i] {
+			fold := Fold{Y: cursor + foldHeight/2, First: rung.Number, Last: rung.Number}
+			for i+1 < len(p.Rungs) && !visible[i+1] {
+				i++
+				fold.Last = p.Rungs[i].Number
+			}
+			folds = append(folds, fold)
+			cursor += foldHeight
+			continue
+		}
+		dy := cursor + foldMargin - rung.Top
+		for _, uid := range rung.Elements {
+			elem := p.Elements[uid].Copy()
+			elem.Translate(0, dy)
+			folded.Elements[uid] = elem
+		}
+		// Power rails span many rungs, each visible rung gets its own piece of them
+		for _, elem := range p.Elements {
+			if isPowerRail(elem) {
+				if piece := railPiece(elem, rung, dy); piece != nil {
+					folded.Elements[piece.UID] = piece
+				}
+			}
+		}
+		folded.Rungs = append(folded.Rungs, &Rung{
+			Number:   rung.Number,
+			Elements: rung.Elements,
+			Top:      rung.Top + dy,
+			Bottom:   rung.Bottom + dy,
+		})
+		cursor += rung.Bottom - rung.Top + 2*foldMargin
+	}
+	folded.rungIndex = make(map[string]*Rung)
+	for _, rung := range folded.Rungs {
+		for _, uid := range rung.Elements {
+			folded.rungIndex[uid] = rung
+		}
+	}
+	return folded, folds
+}
+
+// The part of a power rail next to the rung, moved vertically by dy,
+// nil if the rail doesn't reach the rung
+func railPiece(rail *Element, rung *Rung, dy int) *Element {
+	top := rung.Top - foldMargin/2
+	bottom := rung.Bottom + foldMargin/2
+	if rail.Position.Y > bottom || rail.Position.Y+rail.Height < top {
+		return nil
+	}
+	piece := rail.Copy()
+	piece.UID = fmt.Sprintf("%s@%d", rail.UID, rung.Number)
+	piece.Position.Y = top
+	piece.Height = bottom - top
+	keep := func(pins []*Pin) []*Pin {
+		var kept []*Pin
+		for _, pin := range pins {
+			y := rail.Position.Y + pin.Position.Y
+			if y >= top && y <= bottom {
+				pin.Position.Y = y - top
+				kept = append(kept, pin)
+			}
+		}
+		return kept
+	}
+	piece.Inputs = keep(piece.Inputs)
+	piece.Outputs = keep(piece.Outputs)
+	piece.Translate(0, dy)
+	return piece
+}
@@ -0,0 +1,80 @@
+package elements
+
+import "sort"
+
+// Connectors and continuations sharing a name. A connector takes the wire that
+// ends at it and continues it at every continuation of the same name.
+type Link struct {
+	Name          string
+	Connectors    []string // UIDs, normally a single one
+	Continuations []string // UIDs
+}
+
+// A connector without continuations or a continuation without a connector
+func (l Link) Dangling() bool {
+	return len(l.Connectors) == 0 || len(l.Continuations) == 0
+}
+
+// Partners of the element in the link: continuations of a connector and the other way around
+func (l Link) Partners(uid string) []string {
+	for _, connector := range l.Connectors {
+		if connector == uid {
+			return l.Continuations
+		}
+	}
+	return l.Connectors
+}
+
+// All links of the POU by connector/continuation name
+func (p *POU) Links() map[string]*Link {
+	links := make(map[string]*Link)
+	for uid, elem := range p.Elements {
+		if elem.Type != "connector" && elem.Type != "continuation" {
+			continue
+		}
+		name := elem.ElementText.Value
+		link, ok := links[name]
+		if !ok {
+			link = &Link{Name: name}
+			links[name] = link
+		}
+		if elem.Type == "connector" {
+			link.Connectors = append(link.Connectors, uid)
+		} else {
+			link.Continuations = append(link.Continuations, uid)
+		}
+	}
+	for _, link := range links {
+		sort.Strings(link.Connectors)
+		sort.Strings(link.Continuations)
+	}
+	return links
+}
+
+// UIDs of connectors and continuations that are dangling in the new POU but weren't
+// in the old one, because their partner got deleted or renamed, or they were
+// added or renamed themselves without one. Sorted.
+func BecameDangling(old_pou, new_pou *POU) []string {
+	old_links := old_pou.Links()
+	wasDangling := func(uid string) bool {
+		elem, ok := old_pou.Elements[uid]
+		if !ok {
+			return false
+		}
+		link, ok := old_links[elem.ElementText.Value]
+		return ok && link.Dangling()
+	}
+	var dangling []string
+	for _, link := range new_pou.Links() {
+		if !link.Dangling() {
+			continue
+		}
+		for _, uid := range append(append([]string{}, link.Connectors...), link.Continuations...) {
+			if !wasDangling(uid) {
+				dangling = append(dangling, uid)
+			}
+		}
+	}
+	sort.Strings(dangling)
+	return dangling
+}
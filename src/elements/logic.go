@@ -0,0 +1,446 @@
+package elements
+
+import (
+	"sort"
+	"strings"
+)
+
+// Boolean expression of the power flowing through a wire, built from the
+// variables of the contacts on the way from the left power rail
+type expression struct {
+	op   string // var, not, and, or
+	name string // Variable, literal or block call for var
+	args []*expression
+	text string // String, once computed
+}
+
+var (
+	exprTrue  = &expression{op: "var", name: "TRUE"}
+	exprFalse = &expression{op: "var", name: "FALSE"}
+)
+
+func variableExpr(name string) *expression {
+	return &expression{op: "var", name: name}
+}
+
+func notExpr(arg *expression) *expression {
+	switch {
+	case arg == exprTrue:
+		return exprFalse
+	case arg == exprFalse:
+		return exprTrue
+	case arg.op == "not":
+		return arg.args[0]
+	}
+	return &expression{op: "not", args: []*expression{arg}}
+}
+
+// AND or OR of the arguments, flattened, with constants folded away and
+// repeated arguments dropped
+func junctionExpr(op string, args []*expression) *expression {
+	identity, absorbing := exprTrue, exprFalse
+	if op == "or" {
+		identity, absorbing = exprFalse, exprTrue
+	}
+	var flat []*expression
+	seen := make(map[string]bool)
+	var add func(arg *expression) bool
+	add = func(arg *expression) bool {
+		switch {
+		case arg == absorbing:
+			return false
+		case arg == identity:
+			return true
+		case arg.op == op:
+			for _, nested := range arg.args {
+				if !add(nested) {
+					return false
+				}
+			}
+			return true
+		}
+		if s := arg.String(); !seen[s] {
+			seen[s] = true
+			flat = append(flat, arg)
+		}
+		return true
+	}
+	for _, arg := range args {
+		if !add(arg) {
+			return absorbing
+		}
+	}
+	switch len(flat) {
+	case 0:
+		return identity
+	case 1:
+		return flat[0]
+	}
+	if op == "or" {
+		if factored := factorExpr(flat); factored != nil {
+			return factored
+		}
+	}
+	return &expression{op: op, args: flat}
+}
+
+func conjuncts(e *expression) []*expression {
+	if e.op == "and" {
+		return e.args
+	}
+	return []*expression{e}
+}
+
+// Pulls the conjuncts all alternatives of an OR share out of it, like
+// (a AND c) OR (a AND d) into a AND (c OR d). Parallel branches in series are
+// stored with every element of a branch wired to every output of the branch
+// before it, which would otherwise repeat the earlier branches once for every
+// element of the later ones. Nil if there's nothing to pull out.
+func factorExpr(alternatives []*expression) *expression {
+	counts := make(map[string]int)
+	for _, alternative := range alternatives {
+		seen := make(map[string]bool)
+		for _, conjunct := range conjuncts(alternative) {
+			if s := conjunct.String(); !seen[s] {
+				seen[s] = true
+				counts[s]++
+			}
+		}
+	}
+	var common []*expression
+	shared := make(map[string]bool)
+	for _, conjunct := range conjuncts(alternatives[0]) {
+		if s := conjunct.String(); counts[s] == len(alternatives) && !shared[s] {
+			shared[s] = true
+			common = append(common, conjunct)
+		}
+	}
+	if len(common) == 0 {
+		return nil
+	}
+	rests := make([]*expression, 0, len(alternatives))
+	for _, alternative := range alternatives {
+		var rest []*expression
+		for _, conjunct := range conjuncts(alternative) {
+			if !shared[conjunct.String()] {
+				rest = append(rest, conjunct)
+			}
+		}
+		rests = append(rests, junctionExpr("and", rest))
+	}
+	return junctionExpr("and", append(common, junctionExpr("or", rests)))
+}
+
+// Operators bind NOT before AND before OR, like in structured text
+var expression_precedence = map[string]int{"or": 1, "and": 2, "not": 3, "var": 4}
+
+// Variables are shared between walks, so only compound expressions remember their text
+func (e *expression) String() string {
+	switch {
+	case e.op == "var":
+		return e.name
+	case e.text != "":
+		return e.text
+	case e.op == "not":
+		e.text = "NOT " + e.args[0].operand(expression_precedence["not"])
+		return e.text
+	}
+	parts := make([]string, 0, len(e.args))
+	for _, arg := range e.args {
+		parts = append(parts, arg.operand(expression_precedence[e.op]))
+	}
+	e.text = strings.Join(parts, " "+strings.ToUpper(e.op)+" ")
+	return e.text
+}
+
+// Parenthesized if it binds looser than the operator it's an operand of
+func (e *expression) operand(precedence int) string {
+	if expression_precedence[e.op] <= precedence && e.op != "var" {
+		return "(" + e.String() + ")"
+	}
+	return e.String()
+}
+
+// Boolean expression driving a coil or an output variable
+type Logic struct {
+	POU        string
+	Rung       int    // Zero outside of rungs
+	Element    string // UID of the coil or output variable
+	Kind       string // Like "Set coil" or "Output variable"
+	Variable   string
+	Expression string // Like "(start OR motor) AND NOT stop"
+	Statement  string // Assignment in structured text, like "motor := (start OR motor) AND NOT stop"
+	Diff       Diff   // Between versions, see DiffLogic
+	Old        string // Statement of the old version of a modified assignment
+}
+
+// Derives the expression driving every coil and output variable of the POU by
+// walking the wires back to the left power rail: contacts in series are ANDed,
+// wires joining in parallel are ORed. Blocks show up as calls with their
+// arguments, continuations as the wire ending at their connector. Sorted by
+// rung and element.
+func (p *POU) Logic() []Logic {
+	walker := logicWalker{
+		pou:      p,
+		links:    p.Links(),
+		visiting: make(map[string]bool),
+		outputs:  make(map[string]*expression),
+	}
+	// Walked in a fixed order, what a feedback loop reads depends on where the walk enters it
+	uids := make([]string, 0, len(p.Elements))
+	for uid := range p.Elements {
+		uids = append(uids, uid)
+	}
+	sort.Slice(uids, func(i, j int) bool { return UIDLess(uids[i], uids[j]) })
+	var logic []Logic
+	for _, uid := range uids {
+		elem := p.Elements[uid]
+		var statement string
+		var expr *expression
+		variable := elem.TopLabel.Value
+		switch elem.Type {
+		case "coil":
+			expr = walker.input(elem.Inputs)
+			statement = coilStatement(elem, expr)
+		case "outVariable", "inOutVariable":
+			expr = walker.input(elem.Inputs)
+			variable = elem.ElementText.Value
+			statement = variable + " := " + expr.String()
+		default:
+			continue
+		}
+		entry := Logic{
+			POU:        p.Name,
+			Element:    elem.UID,
+			Kind:       elem.Kind(),
+			Variable:   variable,
+			Expression: expr.String(),
+			Statement:  statement,
+		}
+		if rung := p.RungOf(elem.UID); rung != nil {
+			entry.Rung = rung.Number
+		}
+		logic = append(logic, entry)
+	}
+	SortLogic(logic)
+	return logic
+}
+
+// Walks the wires of a POU back to the left power rail, deriving the expression
+// of every output pin once, no matter how many wires start at it
+type logicWalker struct {
+	pou      *POU
+	links    map[string]*Link
+	visiting map[string]bool
+	outputs  map[string]*expression // By UID and pin label
+}
+
+// Coils write their variable like the CODESYS flavour of structured text:
+// set and reset coils with S= and R=, edge coils through a trigger
+func coilStatement(coil *Element, expr *expression) string {
+	switch coil.Edge.Value {
+	case "P":
+		expr = variableExpr("RISING(" + expr.String() + ")")
+	case "N":
+		expr = variableExpr("FALLING(" + expr.String() + ")")
+	}
+	if coil.Negated.Value != "" {
+		expr = notExpr(expr)
+	}
+	switch coil.Storage.Value {
+	case "S":
+		return coil.TopLabel.Value + " S= " + expr.String()
+	case "R":
+		return coil.TopLabel.Value + " R= " + expr.String()
+	}
+	return coil.TopLabel.Value + " := " + expr.String()
+}
+
+// Power flowing into the input pins: the wires of all of them ORed, no
+// power if nothing is wired to them
+func (w *logicWalker) input(pins []*Pin) *expression {
+	var wires []*expression
+	for _, pin := range pins {
+		for _, conn := range pin.Connections {
+			wires = append(wires, w.output(conn.TargetRef, conn.TargetLabel))
+		}
+	}
+	return junctionExpr("or", wires)
+}
+
+// Power flowing out of the output pin with the given label of the element
+func (w *logicWalker) output(uid, label string) *expression {
+	elem, ok := w.pou.Elements[uid]
+	if !ok {
+		return exprFalse
+	}
+	key := uid + "\x00" + label
+	if expr, ok := w.outputs[key]; ok {
+		return expr
+	}
+	// Feedback loops, like a block output wired back into its input, read the
+	// value of the previous cycle
+	if w.visiting[uid] {
+		return variableExpr(feedbackName(elem, label))
+	}
+	w.visiting[uid] = true
+	expr := w.element(elem, label)
+	delete(w.visiting, uid)
+	w.outputs[key] = expr
+	return expr
+}
+
+func (w *logicWalker) element(elem *Element, label string) *expression {
+	switch elem.Type {
+	case "leftPowerRail":
+		return exprTrue
+	case "contact":
+		return junctionExpr("and", []*expression{w.input(elem.Inputs), contactExpr(elem)})
+	case "coil":
+		// Coils pass the power on to whatever comes after them
+		return w.input(elem.Inputs)
+	case "inVariable", "inOutVariable":
+		text := strings.TrimSpace(elem.ElementText.Value)
+		switch strings.ToUpper(text) {
+		case "TRUE":
+			return exprTrue
+		case "FALSE":
+			return exprFalse
+		}
+		return variableExpr(text)
+	case "continuation":
+		link := w.links[elem.ElementText.Value]
+		if link == nil || len(link.Connectors) == 0 {
+			return exprFalse
+		}
+		var wires []*expression
+		for _, connector := range link.Connectors {
+			wires = append(wires, w.input(w.pou.Elements[connector].Inputs))
+		}
+		return junctionExpr("or", wires)
+	case "block":
+		return variableExpr(w.blockCall(elem, label))
+	}
+	return exprFalse
+}
+
+// Variable of the contact, negated or through an edge trigger
+func contactExpr(contact *Element) *expression {
+	expr := variableExpr(contact.TopLabel.Value)
+	switch contact.Edge.Value {
+	case "P":
+		expr = variableExpr("RISING(" + contact.TopLabel.Value + ")")
+	case "N":
+		expr = variableExpr("FALLING(" + contact.TopLabel.Value + ")")
+	}
+	if contact.Negated.Value != "" {
+		expr = notExpr(expr)
+	}
+	return expr
+}
+
+// Call of the block with its wired inputs as formal arguments, like
+// "TON0(IN := start, PT := T#5s).Q". Functions with a single output are called
+// without naming it.
+func (w *logicWalker) blockCall(block *Element, label string) string {
+	var args []string
+	for _, pin := range block.Inputs {
+		if len(pin.Connections) == 0 {
+			continue
+		}
+		args = append(args, pin.Label.Value+" := "+w.input([]*Pin{pin}).String())
+	}
+	name := block.BlockLabel.Value
+	if block.TopLabel.Value != "" {
+		name = block.TopLabel.Value
+	}
+	call := name + "(" + strings.Join(args, ", ") + ")"
+	if output := blockOutput(block, label); output != "" {
+		call += "." + output
+	}
+	return call
+}
+
+// Name of the output pin a wire starts at, empty if the block has only one
+// output besides ENO
+func blockOutput(block *Element, label string) string {
+	var outputs []string
+	for _, pin := range block.Outputs {
+		if !strings.EqualFold(pin.Label.Value, "ENO") {
+			outputs = append(outputs, pin.Label.Value)
+		}
+	}
+	if len(outputs) <= 1 && !strings.EqualFold(label, "ENO") {
+		return ""
+	}
+	if label == "" && len(outputs) > 0 {
+		return outputs[0]
+	}
+	return label
+}
+
+// What a wire fed back into its own source reads, like TON0.Q
+func feedbackName(elem *Element, label string) string {
+	switch elem.Type {
+	case "block":
+		if elem.TopLabel.Value != "" && label != "" {
+			return elem.TopLabel.Value + "." + label
+		}
+		return elem.BlockLabel.Value + "#" + elem.UID
+	case "contact", "coil":
+		return elem.TopLabel.Value
+	}
+	return "#" + elem.UID
+}
+
+// Sorts assignments by POU, rung and element
+func SortLogic(logic []Logic) {
+	sort.Slice(logic, func(i, j int) bool {
+		a, b := logic[i], logic[j]
+		if a.POU != b.POU {
+			return a.POU < b.POU
+		}
+		if a.Rung != b.Rung {
+			return a.Rung < b.Rung
+		}
+		if a.Element != b.Element {
+			return UIDLess(a.Element, b.Element)
+		}
+		return a.Diff < b.Diff
+	})
+}
+
+// Merges the assignments of two versions by POU and element UID. Ones only in
+// the old version are marked deleted, ones only in the new version added, and
+// ones whose statement changed modified, with the old statement kept.
+func DiffLogic(old_logic, new_logic []Logic) []Logic {
+	key := func(logic Logic) string {
+		return logic.POU + "\x00" + logic.Element
+	}
+	old_by_key := make(map[string]Logic)
+	for _, logic := range old_logic {
+		old_by_key[key(logic)] = logic
+	}
+	in_new := make(map[string]bool)
+	var merged []Logic
+	for _, logic := range new_logic {
+		in_new[key(logic)] = true
+		old, ok := old_by_key[key(logic)]
+		switch {
+		case !ok:
+			logic.Diff = DiffAdded
+		case old.Statement != logic.Statement:
+			logic.Diff = DiffModified
+			logic.Old = old.Statement
+		}
+		merged = append(merged, logic)
+	}
+	for _, logic := range old_logic {
+		if !in_new[key(logic)] {
+			logic.Diff = DiffDeleted
+			merged = append(merged, logic)
+		}
+	}
+	SortLogic(merged)
+	return merged
+}
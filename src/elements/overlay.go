@@ -0,0 +1,95 @@
+package elements
+
+import "fmt"
+
+// Merges both versions of a diffed POU into one: everything of the new version,
+// plus deleted elements and wires of the old one at their old coordinates.
+// Labels can't be drawn twice in the same spot, so label changes of elements that
+// exist in both versions are described separately, by UID.
+// Only meaningful after CalculateDiff. Neither POU is modified.
+func Overlay(old_pou, new_pou *POU) (POU, map[string]string) {
+	overlay := POU{
+		Name:     new_pou.Name,
+		Type:     new_pou.Type,
+		Comment:  new_pou.Comment,
+		Elements: make(map[string]*Element),
+	}
+	descriptions := make(map[string]string)
+	for uid, elem := range new_pou.Elements {
+		overlay.Elements[uid] = elem.Copy()
+	}
+	for uid, old_elem := range old_pou.Elements {
+		new_elem, kept := overlay.Elements[uid]
+		if old_elem.Diff == DiffDeleted {
+			ghost := old_elem.Copy()
+			// Same UID, different element (a block changed its type, for example)
+			if kept {
+				ghost.UID = uid + "'"
+			}
+			overlay.Elements[ghost.UID] = ghost
+			continue
+		}
+		if !kept {
+			continue
+		}
+		new_elem.Inputs = overlayConnections(old_elem.Inputs, new_elem.Inputs)
+		new_elem.Outputs = overlayConnections(old_elem.Outputs, new_elem.Outputs)
+		if description := labelChanges(old_elem, new_pou.Elements[uid]); description != "" {
+			descriptions[uid] = description
+		}
+	}
+	overlay.detectRungs()
+	return overlay, descriptions
+}
+
+// Adds deleted connections of the old pins to the new ones, pins that don't exist anymore included
+func overlayConnections(old_pins, new_pins []*Pin) []*Pin {
+	for i, old_pin := range copyPins(old_pins) {
+		var deleted []*Connection
+		for _, conn := range old_pin.Connections {
+			if conn.Diff == DiffDeleted {
+				deleted = append(deleted, conn)
+			}
+		}
+		if len(deleted) == 0 {
+			continue
+		}
+		if i < len(new_pins) {
+			new_pins[i].Connections = append(new_pins[i].Connections, deleted...)
+			continue
+		}
+		old_pin.Connections = deleted
+		old_pin.Label.Diff = DiffDeleted
+		new_pins = append(new_pins, old_pin)
+	}
+	return new_pins
+}
+
+// Describes changed labels of an element that exists in both versions, like "stop → halt"
+func labelChanges(old_elem, new_elem *Element) string {
+	description := ""
+	for _, label := range []struct {
+		name     string
+		old, new MutableString
+	}{
+		{"label", old_elem.TopLabel, new_elem.TopLabel},
+		{"text", old_elem.ElementText, new_elem.ElementText},
+		{"type", old_elem.BlockLabel, new_elem.BlockLabel},
+		{"execution order", old_elem.ExecutionOrder, new_elem.ExecutionOrder},
+		{"negation", old_elem.Negated, new_elem.Negated},
+		{"edge", old_elem.Edge, new_elem.Edge},
+		{"storage", old_elem.Storage, new_elem.Storage},
+	} {
+		// The text of contacts and coils is made of their modifiers, which are described one by one
+		if label.name == "text" && new_elem.HasModifiers() {
+			continue
+		}
+		if label.old.Value != label.new.Value {
+			if description != "" {
+				description += ", "
+			}
+			description += fmt.Sprintf("%s: %q → %q", label.name, label.old.Value, label.new.Value)
+		}
+	}
+	return description
+}
@@ -0,0 +1,191 @@
+package elements
+
+import (
+	"fmt"
+	"sort"
+)
+
+// A rung is a group of elements connected to each other between the power rails.
+// Rails themselves span several rungs and don't belong to any.
+type Rung struct {
+	Number   int      // Starting from 1, top to bottom
+	Elements []string // UIDs of the elements, sorted
+	Top      int      // Vertical extent of the elements and wires
+	Bottom   int
+}
+
+type RungChangeKind int
+
+const (
+	RungAdded RungChangeKind = iota
+	RungDeleted
+	RungModified
+)
+
+// A change to a single rung. Numbers of deleted rungs refer to the old version,
+// all others to the new one.
+type RungChange struct {
+	Number int
+	Kind   RungChangeKind
+}
+
+func (c RungChange) String() string {
+	switch c.Kind {
+	case RungAdded:
+		return fmt.Sprintf("rung %d added", c.Number)
+	case RungDeleted:
+		return fmt.Sprintf("rung %d deleted", c.Number)
+	}
+	return fmt.Sprintf("rung %d modified", c.Number)
+}
+
+func isPowerRail(elem *Element) bool {
+	return elem.Type == "leftPowerRail" || elem.Type == "rightPowerRail"
+}
+
+// Splits the POU into rungs: connected components of the connection graph, with
+// connectors joined to continuations of the same name, ordered by vertical position
+func (p *POU) detectRungs() {
+	parent := make(map[string]string)
+	var find func(uid string) string
+	find = func(uid string) string {
+		if parent[uid] != uid {
+			parent[uid] = find(parent[uid])
+		}
+		return parent[uid]
+	}
+	union := func(a, b string) {
+		parent[find(a)] = find(b)
+	}
+	connectors := make(map[string]string)
+	for uid, elem := range p.Elements {
+		if !isPowerRail(elem) {
+			parent[uid] = uid
+		}
+	}
+	for uid, elem := range p.Elements {
+		if isPowerRail(elem) {
+			continue
+		}
+		for _, pins := range [][]*Pin{elem.Inputs, elem.Outputs} {
+			for _, pin := range pins {
+				for _, conn := range pin.Connections {
+					if _, ok := parent[conn.TargetRef]; ok {
+						union(uid, conn.TargetRef)
+					}
+				}
+			}
+		}
+		if elem.Type == "connector" || elem.Type == "continuation" {
+			if other, ok := connectors[elem.ElementText.Value]; ok {
+				union(uid, other)
+			} else {
+				connectors[elem.ElementText.Value] = uid
+			}
+		}
+	}
+	groups := make(map[string]*Rung)
+	for uid := range parent {
+		root := find(uid)
+		if groups[root] == nil {
+			groups[root] = &Rung{Top: p.Elements[uid].Position.Y, Bottom: p.Elements[uid].Position.Y}
+		}
+		rung := groups[root]
+		rung.Elements = append(rung.Elements, uid)
+		rung.extend(p.Elements[uid])
+	}
+	p.Rungs = nil
+	for _, rung := range groups {
+		sort.Strings(rung.Elements)
+		p.Rungs = append(p.Rungs, rung)
+	}
+	sort.Slice(p.Rungs, func(i, j int) bool {
+		if p.Rungs[i].Top != p.Rungs[j].Top {
+			return p.Rungs[i].Top < p.Rungs[j].Top
+		}
+		return p.Rungs[i].Elements[0] < p.Rungs[j].Elements[0]
+	})
+	p.rungIndex = make(map[string]*Rung)
+	for i, rung := range p.Rungs {
+		rung.Number = i + 1
+		for _, uid := range rung.Elements {
+			p.rungIndex[uid] = rung
+		}
+	}
+}
+
+// Grows the vertical extent of the rung to cover the element and its wires
+func (r *Rung) extend(elem *Element) {
+	r.cover(elem.Position.Y)
+	r.cover(elem.Position.Y + elem.Height)
+	for _, pins := range [][]*Pin{elem.Inputs, elem.Outputs} {
+		for _, pin := range pins {
+			for _, conn := range pin.Connections {
+				for _, point := range conn.Points {
+					r.cover(point.Y)
+				}
+			}
+		}
+	}
+}
+
+func (r *Rung) cover(y int) {
+	if y < r.Top {
+		r.Top = y
+	}
+	if y > r.Bottom {
+		r.Bottom = y
+	}
+}
+
+// Returns the rung the element belongs to, nil for power rails and unknown elements
+func (p *POU) RungOf(uid string) *Rung {
+	return p.rungIndex[uid]
+}
+
+// Reports whether any element of the rung has changed, only meaningful after CalculateDiff
+func (p *POU) RungHasChanges(rung *Rung) bool {
+	for _, uid := range rung.Elements {
+		if p.Elements[uid].HasChanges() {
+			return true
+		}
+	}
+	return false
+}
+
+// Summarizes the diff per rung, only meaningful after CalculateDiff. Rungs of both
+// versions are matched through the elements they share.
+func (p *POU) RungChanges(new_pou *POU) []RungChange {
+	var changes []RungChange
+	for _, rung := range new_pou.Rungs {
+		added := true
+		modified := new_pou.RungHasChanges(rung)
+		for _, uid := range rung.Elements {
+			if new_pou.Elements[uid].Diff != DiffAdded {
+				added = false
+			}
+			// Something could have been removed from the matching old rung
+			if old_rung := p.RungOf(uid); old_rung != nil && p.RungHasChanges(old_rung) {
+				modified = true
+			}
+		}
+		switch {
+		case added:
+			changes = append(changes, RungChange{Number: rung.Number, Kind: RungAdded})
+		case modified:
+			changes = append(changes, RungChange{Number: rung.Number, Kind: RungModified})
+		}
+	}
+	for _, rung := range p.Rungs {
+		deleted := true
+		for _, uid := range rung.Elements {
+			if p.Elements[uid].Diff != DiffDeleted {
+				deleted = false
+			}
+		}
+		if deleted {
+			changes = append(changes, RungChange{Number: rung.Number, Kind: RungDeleted})
+		}
+	}
+	return changes
+}
@@ -0,0 +1,116 @@
+package elements
+
+// Absolute position of one of the element's pins, pin positions are stored relative to the element
+func (e *Element) PinPosition(pin *Pin) Position {
+	return Position{
+		X: e.Position.X + pin.Position.X,
+		Y: e.Position.Y + pin.Position.Y,
+	}
+}
+
+// Repairs the stored wire geometry so that every wire runs orthogonally from
+// its pin to the pin it's connected to. Older or hand-edited files have wires
+// without points, with endpoints missing or off the pins, or with diagonal
+// segments. Wires to elements that don't exist are left as they are, and so
+// are wires ending anywhere along a power rail, which is a continuous bar.
+func (p *POU) resolveWires() {
+	for _, elem := range p.Elements {
+		for _, pin := range elem.Inputs {
+			for _, conn := range pin.Connections {
+				if target, ok := p.Elements[conn.TargetRef]; ok {
+					p.resolveWire(elem.PinPosition(pin), conn, target, target.Outputs)
+				}
+			}
+		}
+		// Connections are normally stored on the input side, but the other way around happens too
+		for _, pin := range elem.Outputs {
+			for _, conn := range pin.Connections {
+				if target, ok := p.Elements[conn.TargetRef]; ok {
+					p.resolveWire(elem.PinPosition(pin), conn, target, target.Inputs)
+				}
+			}
+		}
+	}
+}
+
+func (p *POU) resolveWire(start Position, conn *Connection, target *Element, target_pins []*Pin) {
+	target_pin := connectedPin(conn, start, target, target_pins)
+	if target_pin == nil {
+		return
+	}
+	end := target.PinPosition(target_pin)
+	if len(conn.Points) == 0 {
+		conn.Points = route(start, end)
+		return
+	}
+	points := conn.Points
+	if *points[0] != start {
+		points = append([]*Position{&start}, points...)
+	}
+	if last := *points[len(points)-1]; last != end && !(isPowerRail(target) && abs(last.X-end.X) <= target.Width) {
+		points = append(points, &end)
+	}
+	conn.Points = orthogonal(points)
+}
+
+// Pin of the target the connection ends at: the one named by the connection if
+// any, otherwise the one closest to where the stored wire ends, or to the start
+// of the wire if there are no points. Power rails have several unnamed pins.
+func connectedPin(conn *Connection, start Position, target *Element, pins []*Pin) *Pin {
+	if conn.TargetLabel != "" {
+		for _, pin := range pins {
+			if pin.Label.Value == conn.TargetLabel {
+				return pin
+			}
+		}
+	}
+	near := start
+	if len(conn.Points) > 0 {
+		near = *conn.Points[len(conn.Points)-1]
+	}
+	var closest *Pin
+	closest_distance := 0
+	for _, pin := range pins {
+		position := target.PinPosition(pin)
+		distance := abs(position.X-near.X) + abs(position.Y-near.Y)
+		if closest == nil || distance < closest_distance {
+			closest = pin
+			closest_distance = distance
+		}
+	}
+	return closest
+}
+
+// Orthogonal wire between two pins, with the vertical run halfway between them
+func route(start, end Position) []*Position {
+	if start.Y == end.Y {
+		return []*Position{&start, &end}
+	}
+	middle := (start.X + end.X) / 2
+	return []*Position{
+		&start,
+		{X: middle, Y: start.Y},
+		{X: middle, Y: end.Y},
+		&end,
+	}
+}
+
+// Replaces diagonal segments with orthogonal detours
+func orthogonal(points []*Position) []*Position {
+	resolved := []*Position{points[0]}
+	for _, point := range points[1:] {
+		previous := resolved[len(resolved)-1]
+		if previous.X != point.X && previous.Y != point.Y {
+			resolved = append(resolved, route(*previous, *point)[1:3]...)
+		}
+		resolved = append(resolved, point)
+	}
+	return resolved
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
@@ -0,0 +1,166 @@
+package elements
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// How an element uses a variable
+type Access int
+
+const (
+	AccessRead Access = iota
+	AccessWrite
+	AccessReadWrite
+)
+
+func (a Access) String() string {
+	switch a {
+	case AccessWrite:
+		return "write"
+	case AccessReadWrite:
+		return "read/write"
+	}
+	return "read"
+}
+
+// A single place a variable is used at
+type Usage struct {
+	Variable string
+	POU      string
+	Rung     int    // Zero outside of rungs
+	Element  string // UID
+	Kind     string // Like "Set coil" or "TON block"
+	Pin      string // Block pin the variable is the argument of, empty for other elements
+	Access   Access
+	Diff     Diff // Added or deleted between versions, see DiffUsages
+}
+
+// Identifies the usage across versions, regardless of the rung it ended up in
+func (u Usage) key() string {
+	return strings.Join([]string{u.Variable, u.POU, u.Element, u.Pin, u.Access.String()}, "\x00")
+}
+
+// Direct addresses like %IX0.1 and (qualified) identifiers, possibly indexed.
+// Literals like 10, T#5s, 'text' or TRUE aren't variables.
+var variable_pattern = regexp.MustCompile(`^(%[IQM][XBWDL]?[0-9]+(\.[0-9]+)*|[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*|\[[^\]]*\])*)$`)
+
+func isVariable(expression string) bool {
+	switch strings.ToUpper(expression) {
+	case "TRUE", "FALSE":
+		return false
+	}
+	return variable_pattern.MatchString(expression)
+}
+
+// Every use of a variable in the POU: contacts read theirs, coils write theirs,
+// variable boxes are read, written or both depending on their type, and block
+// pins read or write the arguments folded into them. Variables wired to a block
+// pin count as arguments of that pin. Sorted by variable, rung and element.
+func (p *POU) Usages() []Usage {
+	compact := p.Compact()
+	var usages []Usage
+	add := func(elem *Element, variable, pin string, access Access) {
+		variable = strings.TrimSpace(variable)
+		if !isVariable(variable) {
+			return
+		}
+		usage := Usage{
+			Variable: variable,
+			POU:      p.Name,
+			Element:  elem.UID,
+			Kind:     elem.Kind(),
+			Pin:      pin,
+			Access:   access,
+		}
+		if rung := compact.RungOf(elem.UID); rung != nil {
+			usage.Rung = rung.Number
+		}
+		usages = append(usages, usage)
+	}
+	for _, elem := range compact.Elements {
+		switch elem.Type {
+		case "contact":
+			add(elem, elem.TopLabel.Value, "", AccessRead)
+		case "coil":
+			add(elem, elem.TopLabel.Value, "", AccessWrite)
+		case "inVariable":
+			add(elem, elem.ElementText.Value, "", AccessRead)
+		case "outVariable":
+			add(elem, elem.ElementText.Value, "", AccessWrite)
+		case "inOutVariable":
+			add(elem, elem.ElementText.Value, "", AccessReadWrite)
+		case "block":
+			for _, pin := range elem.Inputs {
+				add(elem, pin.Argument.Value, pin.Label.Value, AccessRead)
+			}
+			for _, pin := range elem.Outputs {
+				add(elem, pin.Argument.Value, pin.Label.Value, AccessWrite)
+			}
+		}
+	}
+	SortUsages(usages)
+	return usages
+}
+
+// Sorts usages by variable, POU, rung, element and pin
+func SortUsages(usages []Usage) {
+	sort.Slice(usages, func(i, j int) bool {
+		a, b := usages[i], usages[j]
+		if a.Variable != b.Variable {
+			return a.Variable < b.Variable
+		}
+		if a.POU != b.POU {
+			return a.POU < b.POU
+		}
+		if a.Rung != b.Rung {
+			return a.Rung < b.Rung
+		}
+		if a.Element != b.Element {
+			return UIDLess(a.Element, b.Element)
+		}
+		if a.Pin != b.Pin {
+			return a.Pin < b.Pin
+		}
+		return a.Diff < b.Diff
+	})
+}
+
+// Orders UIDs, numeric local IDs by value
+func UIDLess(a, b string) bool {
+	x, err_x := strconv.Atoi(a)
+	y, err_y := strconv.Atoi(b)
+	if err_x == nil && err_y == nil {
+		return x < y
+	}
+	return a < b
+}
+
+// Merges the usages of two versions, marking the ones only in the old version
+// deleted and the ones only in the new version added. Usages in both keep the
+// rung they have in the new version.
+func DiffUsages(old_usages, new_usages []Usage) []Usage {
+	in_new := make(map[string]bool)
+	for _, usage := range new_usages {
+		in_new[usage.key()] = true
+	}
+	in_old := make(map[string]bool)
+	var merged []Usage
+	for _, usage := range old_usages {
+		in_old[usage.key()] = true
+		if !in_new[usage.key()] {
+			usage.Diff = DiffDeleted
+			merged = append(merged, usage)
+		}
+	}
+	for _, usage := range new_usages {
+		if !in_old[usage.key()] {
+			usage.Diff = DiffAdded
+		}
+		merged = append(merged, usage)
+	}
+	SortUsages(merged)
+	return merged
+}
@@ -0,0 +1,103 @@
+package export
+
+import (
+	"sync"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goitalic"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+)
+
+// The Go fonts stand in for the sans-serif fonts themes name, they're embedded
+// so that exports look the same on every machine
+var parseFonts = sync.OnceValues(func() (map[fontStyle]*opentype.Font, error) {
+	fonts := make(map[fontStyle]*opentype.Font)
+	for style, data := range map[fontStyle][]byte{
+		{}:             goregular.TTF,
+		{Bold: true}:   gobold.TTF,
+		{Italic: true}: goitalic.TTF,
+	} {
+		parsed, err := opentype.Parse(data)
+		if err != nil {
+			return nil, err
+		}
+		fonts[style] = parsed
+	}
+	return fonts, nil
+})
+
+type fontStyle struct {
+	Bold   bool
+	Italic bool
+}
+
+type faceKey struct {
+	style fontStyle
+	size  float64
+}
+
+// Font faces of a single export. Faces aren't safe for concurrent use,
+// so every export gets its own set.
+type fontSet struct {
+	fonts map[fontStyle]*opentype.Font
+	faces map[faceKey]font.Face
+	scale float64 // Device pixels per SVG unit
+}
+
+func newFontSet(scale float64) (*fontSet, error) {
+	fonts, err := parseFonts()
+	if err != nil {
+		return nil, err
+	}
+	return &fontSet{fonts: fonts, faces: make(map[faceKey]font.Face), scale: scale}, nil
+}
+
+// Face for the run, scaled to device pixels. Bold italic isn't used by the renderer, bold wins.
+func (f *fontSet) face(run textRun) (font.Face, error) {
+	style := fontStyle{Bold: run.Bold, Italic: run.Italic && !run.Bold}
+	key := faceKey{style, run.Size}
+	if face, ok := f.faces[key]; ok {
+		return face, nil
+	}
+	face, err := opentype.NewFace(f.fonts[style], &opentype.FaceOptions{
+		Size:    run.Size * f.scale,
+		DPI:     72,
+		Hinting: font.HintingNone,
+	})
+	if err != nil {
+		return nil, err
+	}
+	f.faces[key] = face
+	return face, nil
+}
+
+// Width of the run in SVG units
+func (f *fontSet) width(run textRun) (float64, error) {
+	face, err := f.face(run)
+	if err != nil {
+		return 0, err
+	}
+	advance := font.MeasureString(face, run.Content)
+	return float64(advance) / 64 / f.scale, nil
+}
+
+// Horizontal start of the run in SVG units, after applying the text anchor.
+// Runs with a length are anchored at that width instead of their natural one.
+func (f *fontSet) start(run textRun) (x, width float64, err error) {
+	width, err = f.width(run)
+	if err != nil {
+		return 0, 0, err
+	}
+	if run.Length > 0 {
+		width = run.Length
+	}
+	switch run.Anchor {
+	case "middle":
+		return run.X - width/2, width, nil
+	case "end":
+		return run.X - width, width, nil
+	}
+	return run.X, width, nil
+}
@@ -0,0 +1,385 @@
+// Draws rendered diagrams onto other surfaces than SVG, like PNG images or PDF
+// documents. Works on the geometry of svg.SVGFile, so what gets exported is
+// exactly what the SVG shows, minus interactive parts like tooltips.
+package export
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+	"strconv"
+	"strings"
+
+	svg "openplc-render/svg"
+)
+
+type point struct {
+	X, Y float64
+}
+
+// A single line of text, in SVG units
+type textRun struct {
+	X, Y    float64
+	Content string
+	Size    float64
+	Bold    bool
+	Italic  bool
+	Anchor  string // start, middle or end
+	Strike  bool
+	Color   color.NRGBA
+	Length  float64 // Width the text is squeezed or stretched to, like textLength in SVG, natural width if zero
+}
+
+// Surface the geometry of a diagram is drawn onto, in SVG units
+type canvas interface {
+	fill(points []point, c color.NRGBA)
+	stroke(points []point, c color.NRGBA, width float64, dashes []float64)
+	text(run textRun)
+}
+
+// Font size browsers use when none is given
+const defaultFontSize = 16
+
+// Number of straight segments a curve is flattened into
+const curveSegments = 16
+
+// Top left corner and size of the diagram in SVG units
+func viewBox(file svg.SVGFile) (origin point, width, height float64, err error) {
+	if _, err := fmt.Sscanf(file.ViewBox, "%g %g %g %g", &origin.X, &origin.Y, &width, &height); err != nil {
+		return point{}, 0, 0, fmt.Errorf("error: unexpected view box %q: %w", file.ViewBox, err)
+	}
+	return origin, width, height, nil
+}
+
+// Draws the diagram with the top left corner of its view box at the origin of the canvas
+func draw(c canvas, file svg.SVGFile, origin point) {
+	if origin != (point{}) {
+		c = &shifted{canvas: c, dx: -origin.X, dy: -origin.Y}
+	}
+	for _, elem := range file.Elements {
+		drawElement(c, elem)
+	}
+}
+
+// Canvas drawing everything moved by an offset
+type shifted struct {
+	canvas
+	dx, dy float64
+}
+
+func (s *shifted) shift(points []point) []point {
+	moved := make([]point, len(points))
+	for i, p := range points {
+		moved[i] = point{p.X + s.dx, p.Y + s.dy}
+	}
+	return moved
+}
+
+func (s *shifted) fill(points []point, c color.NRGBA) {
+	s.canvas.fill(s.shift(points), c)
+}
+
+func (s *shifted) stroke(points []point, c color.NRGBA, width float64, dashes []float64) {
+	s.canvas.stroke(s.shift(points), c, width, dashes)
+}
+
+func (s *shifted) text(run textRun) {
+	run.X += s.dx
+	run.Y += s.dy
+	s.canvas.text(run)
+}
+
+func drawElement(c canvas, elem svg.Element) {
+	switch e := elem.(type) {
+	case svg.Background:
+		if fill, ok := parseColor(e.Fill, 0); ok {
+			x, y := float64(e.X), float64(e.Y)
+			w, h := float64(e.Width), float64(e.Height)
+			c.fill([]point{{x, y}, {x + w, y}, {x + w, y + h}, {x, y + h}}, fill)
+		}
+	case svg.Rect:
+		drawRect(c, e)
+	case svg.Line:
+		drawLine(c, e)
+	case svg.Polyline:
+		drawPolyline(c, e)
+	case svg.Path:
+		drawPath(c, e)
+	case svg.Text:
+		drawText(c, e)
+	case svg.Anchor:
+		drawElement(c, e.Group)
+	case svg.Group:
+		// Same order the group is marshalled in, so that overlapping shapes stack the same way
+		for _, line := range e.Line {
+			drawLine(c, line)
+		}
+		for _, rect := range e.Rect {
+			drawRect(c, rect)
+		}
+		for _, text := range e.Text {
+			drawText(c, text)
+		}
+		for _, path := range e.Path {
+			drawPath(c, path)
+		}
+		for _, polyline := range e.Polyline {
+			drawPolyline(c, polyline)
+		}
+	}
+}
+
+func drawRect(c canvas, rect svg.Rect) {
+	x, y := float64(rect.X), float64(rect.Y)
+	w, h := float64(rect.Width), float64(rect.Height)
+	outline := []point{{x, y}, {x + w, y}, {x + w, y + h}, {x, y + h}}
+	if fill, ok := parseColor(defaultFill(rect.Fill), rect.FillOpacity); ok {
+		c.fill(outline, fill)
+	}
+	if stroke, ok := parseColor(rect.Stroke, 0); ok {
+		c.stroke(append(outline, outline[0]), stroke, strokeWidth(rect.StrokeWidth), parseDashes(rect.StrokeDasharray))
+	}
+}
+
+func drawLine(c canvas, line svg.Line) {
+	if stroke, ok := parseColor(line.Stroke, line.StrokeOpacity); ok {
+		points := []point{{float64(line.X1), float64(line.Y1)}, {float64(line.X2), float64(line.Y2)}}
+		c.stroke(points, stroke, strokeWidth(line.StrokeWidth), parseDashes(line.StrokeDasharray))
+	}
+}
+
+func drawPolyline(c canvas, polyline svg.Polyline) {
+	points := parsePoints(polyline.Points)
+	if len(points) == 0 {
+		return
+	}
+	if fill, ok := parseColor(defaultFill(polyline.Fill), polyline.FillOpacity); ok {
+		c.fill(points, fill)
+	}
+	if stroke, ok := parseColor(polyline.Stroke, 0); ok {
+		c.stroke(points, stroke, strokeWidth(polyline.StrokeWidth), parseDashes(polyline.StrokeDasharray))
+	}
+}
+
+func drawPath(c canvas, path svg.Path) {
+	for _, points := range parsePath(path.D) {
+		if fill, ok := parseColor(defaultFill(path.Fill), path.FillOpacity); ok {
+			c.fill(points, fill)
+		}
+		if stroke, ok := parseColor(path.Stroke, 0); ok {
+			c.stroke(points, stroke, strokeWidth(path.StrokeWidth), parseDashes(path.StrokeDasharray))
+		}
+	}
+}
+
+func drawText(c canvas, text svg.Text) {
+	fill, ok := parseColor(defaultFill(text.Fill), text.FillOpacity)
+	if !ok || text.Content == "" {
+		return
+	}
+	size := float64(defaultFontSize)
+	if s, err := strconv.ParseFloat(text.FontSize, 64); err == nil {
+		size = s
+	}
+	length, _ := strconv.ParseFloat(text.TextLength, 64)
+	c.text(textRun{
+		X:       float64(text.X),
+		Y:       float64(text.Y),
+		Content: text.Content,
+		Size:    size,
+		Bold:    text.FontWeight == "bold",
+		Italic:  text.FontStyle == "italic",
+		Anchor:  text.TextAnchor,
+		Strike:  text.TextDecoration == "line-through",
+		Color:   fill,
+		Length:  length,
+	})
+}
+
+// Shapes without a fill attribute are filled black in SVG
+func defaultFill(fill string) string {
+	if fill == "" {
+		return "black"
+	}
+	return fill
+}
+
+// Stroke width attributes that are left out default to 1 in SVG
+func strokeWidth(width int) float64 {
+	if width == 0 {
+		return 1
+	}
+	return float64(width)
+}
+
+// Parses "x,y x,y ..." point lists of polylines
+func parsePoints(points string) []point {
+	var parsed []point
+	for _, pair := range strings.Fields(points) {
+		var p point
+		if _, err := fmt.Sscanf(pair, "%g,%g", &p.X, &p.Y); err == nil {
+			parsed = append(parsed, p)
+		}
+	}
+	return parsed
+}
+
+// Flattens the subset of path data the renderer produces (absolute M, L and Q
+// commands) into polylines, one per subpath
+func parsePath(d string) [][]point {
+	var subpaths [][]point
+	var current []point
+	fields := strings.Fields(strings.ReplaceAll(d, ",", " "))
+	numbers := func(i, count int) ([]float64, bool) {
+		if i+count > len(fields) {
+			return nil, false
+		}
+		values := make([]float64, count)
+		for j := range values {
+			value, err := strconv.ParseFloat(fields[i+j], 64)
+			if err != nil {
+				return nil, false
+			}
+			values[j] = value
+		}
+		return values, true
+	}
+	for i := 0; i < len(fields); {
+		command := fields[i]
+		i++
+		switch command {
+		case "M":
+			values, ok := numbers(i, 2)
+			if !ok {
+				return subpaths
+			}
+			if len(current) > 1 {
+				subpaths = append(subpaths, current)
+			}
+			current = []point{{values[0], values[1]}}
+			i += 2
+		case "L":
+			values, ok := numbers(i, 2)
+			if !ok || len(current) == 0 {
+				return subpaths
+			}
+			current = append(current, point{values[0], values[1]})
+			i += 2
+		case "Q":
+			values, ok := numbers(i, 4)
+			if !ok || len(current) == 0 {
+				return subpaths
+			}
+			start := current[len(current)-1]
+			control, end := point{values[0], values[1]}, point{values[2], values[3]}
+			for step := 1; step <= curveSegments; step++ {
+				t := float64(step) / curveSegments
+				current = append(current, point{
+					X: (1-t)*(1-t)*start.X + 2*(1-t)*t*control.X + t*t*end.X,
+					Y: (1-t)*(1-t)*start.Y + 2*(1-t)*t*control.Y + t*t*end.Y,
+				})
+			}
+			i += 4
+		case "Z", "z":
+			if len(current) > 0 {
+				current = append(current, current[0])
+			}
+		default:
+			return subpaths
+		}
+	}
+	if len(current) > 1 {
+		subpaths = append(subpaths, current)
+	}
+	return subpaths
+}
+
+// Parses stroke-dasharray, an odd number of values is repeated like SVG does
+func parseDashes(dasharray string) []float64 {
+	var dashes []float64
+	for _, field := range strings.FieldsFunc(dasharray, func(r rune) bool { return r == ',' || r == ' ' }) {
+		value, err := strconv.ParseFloat(field, 64)
+		if err != nil || value < 0 {
+			return nil
+		}
+		dashes = append(dashes, value)
+	}
+	if len(dashes)%2 == 1 {
+		dashes = append(dashes, dashes...)
+	}
+	total := 0.0
+	for _, dash := range dashes {
+		total += dash
+	}
+	if total == 0 {
+		return nil
+	}
+	return dashes
+}
+
+var named_colors = map[string]color.NRGBA{
+	"black":  {0, 0, 0, 255},
+	"white":  {255, 255, 255, 255},
+	"red":    {255, 0, 0, 255},
+	"green":  {0, 128, 0, 255},
+	"blue":   {0, 0, 255, 255},
+	"yellow": {255, 255, 0, 255},
+	"orange": {255, 165, 0, 255},
+	"gray":   {128, 128, 128, 255},
+	"grey":   {128, 128, 128, 255},
+}
+
+// Parses the color formats used by the renderer and themes: names, #rgb, #rrggbb,
+// rgb() and hsl(). Returns false for no paint at all, like none or transparent.
+// An opacity of 0 means the attribute was left out, so the color is opaque.
+func parseColor(value string, opacity float32) (color.NRGBA, bool) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	var c color.NRGBA
+	switch {
+	case value == "" || value == "none" || value == "transparent":
+		return c, false
+	case strings.HasPrefix(value, "#"):
+		hex := value[1:]
+		if len(hex) == 3 {
+			hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+		}
+		rgb, err := strconv.ParseUint(hex, 16, 32)
+		if err != nil || len(hex) != 6 {
+			return c, false
+		}
+		c = color.NRGBA{uint8(rgb >> 16), uint8(rgb >> 8), uint8(rgb), 255}
+	case strings.HasPrefix(value, "rgb("):
+		var r, g, b uint8
+		if _, err := fmt.Sscanf(strings.ReplaceAll(value, " ", ""), "rgb(%d,%d,%d)", &r, &g, &b); err != nil {
+			return c, false
+		}
+		c = color.NRGBA{r, g, b, 255}
+	case strings.HasPrefix(value, "hsl("):
+		var h, s, l float64
+		if _, err := fmt.Sscanf(strings.ReplaceAll(value, " ", ""), "hsl(%g,%g%%,%g%%)", &h, &s, &l); err != nil {
+			return c, false
+		}
+		c = hslToRGB(h, s/100, l/100)
+	default:
+		named, ok := named_colors[value]
+		if !ok {
+			return c, false
+		}
+		c = named
+	}
+	if opacity > 0 && opacity < 1 {
+		c.A = uint8(math.Round(float64(opacity) * 255))
+	}
+	return c, true
+}
+
+func hslToRGB(h, s, l float64) color.NRGBA {
+	h = math.Mod(math.Mod(h, 360)+360, 360) / 360
+	chroma := (1 - math.Abs(2*l-1)) * s
+	channel := func(n float64) uint8 {
+		k := math.Mod(n+h*12, 12)
+		value := l - chroma/2*math.Max(-1, math.Min(math.Min(k-3, 9-k), 1))
+		return uint8(math.Round(value * 255))
+	}
+	return color.NRGBA{channel(0), channel(8), channel(4), 255}
+}
@@ -0,0 +1,309 @@
+package export
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"image/color"
+	"io"
+	"math"
+	"sort"
+	"strings"
+
+	svg "openplc-render/svg"
+)
+
+// Page size in points
+type Paper struct {
+	Name   string
+	Width  float64 // Portrait, pages are turned to landscape for wide diagrams
+	Height float64
+}
+
+var Papers = map[string]Paper{
+	"a4": {Name: "A4", Width: 595.28, Height: 841.89},
+	"a3": {Name: "A3", Width: 841.89, Height: 1190.55},
+}
+
+// A rendered diagram with the name it's captioned with in documents
+type Diagram struct {
+	Name string
+	File svg.SVGFile
+}
+
+// Points per SVG unit, SVG units being CSS pixels at 96 DPI
+const pdfScale = 0.75
+
+// Page margin in points, the caption of tiled pages goes into the bottom one
+const pdfMargin = 36
+
+// Standard fonts every PDF viewer has, text is measured with the Go fonts
+// that are metrically close to them
+var pdfFonts = []struct {
+	style fontStyle
+	name  string // Resource name
+	base  string
+}{
+	{fontStyle{}, "F1", "Helvetica"},
+	{fontStyle{Bold: true}, "F2", "Helvetica-Bold"},
+	{fontStyle{Italic: true}, "F3", "Helvetica-Oblique"},
+}
+
+// Writes the diagrams into a single PDF document, every diagram starting on a new
+// page. Diagrams that don't fit the paper at their natural size are tiled over as
+// many pages as needed, captioned with the diagram name and the tile position.
+func PDF(w io.Writer, diagrams []Diagram, paper Paper) error {
+	doc := &pdfDocument{}
+	catalog := doc.reserve()
+	pages := doc.reserve()
+	var fonts []string
+	for _, f := range pdfFonts {
+		id := doc.add(fmt.Sprintf("<< /Type /Font /Subtype /Type1 /BaseFont /%s /Encoding /WinAnsiEncoding >>", f.base))
+		fonts = append(fonts, fmt.Sprintf("/%s %d 0 R", f.name, id))
+	}
+	fontResources := "/Font << " + strings.Join(fonts, " ") + " >>"
+	var kids []string
+	for i, diagram := range diagrams {
+		origin, width, height, err := viewBox(diagram.File)
+		if err != nil {
+			return err
+		}
+		fontSet, err := newFontSet(1)
+		if err != nil {
+			return err
+		}
+		c := &pdfCanvas{fonts: fontSet, opacities: make(map[uint8]string)}
+		draw(c, diagram.File, origin)
+		if c.err != nil {
+			return c.err
+		}
+		form, err := doc.addStream(fmt.Sprintf("/Type /XObject /Subtype /Form /BBox [0 0 %s %s] /Resources << %s%s >>",
+			num(width), num(height), fontResources, c.stateResources()), c.content.Bytes())
+		if err != nil {
+			return err
+		}
+
+		pageWidth, pageHeight := paper.Width, paper.Height
+		if width > height {
+			pageWidth, pageHeight = pageHeight, pageWidth
+		}
+		tileWidth, tileHeight := pageWidth-2*pdfMargin, pageHeight-2*pdfMargin
+		columns := int(math.Max(1, math.Ceil(width*pdfScale/tileWidth)))
+		rows := int(math.Max(1, math.Ceil(height*pdfScale/tileHeight)))
+		for row := 0; row < rows; row++ {
+			for column := 0; column < columns; column++ {
+				var content bytes.Buffer
+				// Clip to the tile and place the part of the diagram that belongs on it, flipping the y axis
+				fmt.Fprintf(&content, "q %s %s %s %s re W n\n", num(pdfMargin), num(pdfMargin), num(tileWidth), num(tileHeight))
+				fmt.Fprintf(&content, "%s 0 0 %s %s %s cm /X%d Do Q\n", num(pdfScale), num(-pdfScale),
+					num(pdfMargin-float64(column)*tileWidth), num(pageHeight-pdfMargin+float64(row)*tileHeight), i)
+				caption := diagram.Name
+				if rows*columns > 1 {
+					caption += fmt.Sprintf(", page %d of %d (row %d, column %d)", row*columns+column+1, rows*columns, row+1, column+1)
+				}
+				fmt.Fprintf(&content, "BT /F1 8 Tf 0 g %s %s Td (%s) Tj ET\n", num(pdfMargin), num(pdfMargin/2), pdfString(caption))
+				contents, err := doc.addStream("", content.Bytes())
+				if err != nil {
+					return err
+				}
+				page := doc.add(fmt.Sprintf("<< /Type /Page /Parent %d 0 R /MediaBox [0 0 %s %s] /Resources << %s /XObject << /X%d %d 0 R >> >> /Contents %d 0 R >>",
+					pages, num(pageWidth), num(pageHeight), fontResources, i, form, contents))
+				kids = append(kids, fmt.Sprintf("%d 0 R", page))
+			}
+		}
+	}
+	doc.set(pages, fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(kids)))
+	doc.set(catalog, fmt.Sprintf("<< /Type /Catalog /Pages %d 0 R >>", pages))
+	return doc.write(w, catalog)
+}
+
+// Objects of a PDF file, numbered from 1 in the order they're added
+type pdfDocument struct {
+	objects [][]byte
+}
+
+func (d *pdfDocument) reserve() int {
+	d.objects = append(d.objects, nil)
+	return len(d.objects)
+}
+
+func (d *pdfDocument) set(id int, object string) {
+	d.objects[id-1] = []byte(object)
+}
+
+func (d *pdfDocument) add(object string) int {
+	id := d.reserve()
+	d.set(id, object)
+	return id
+}
+
+// Adds a compressed stream, dictionary holds the entries besides the length and filter
+func (d *pdfDocument) addStream(dictionary string, data []byte) (int, error) {
+	var compressed bytes.Buffer
+	zw := zlib.NewWriter(&compressed)
+	if _, err := zw.Write(data); err != nil {
+		return 0, err
+	}
+	if err := zw.Close(); err != nil {
+		return 0, err
+	}
+	var object bytes.Buffer
+	fmt.Fprintf(&object, "<< %s /Length %d /Filter /FlateDecode >>\nstream\n", dictionary, compressed.Len())
+	object.Write(compressed.Bytes())
+	object.WriteString("\nendstream")
+	id := d.reserve()
+	d.objects[id-1] = object.Bytes()
+	return id, nil
+}
+
+func (d *pdfDocument) write(w io.Writer, root int) error {
+	var out bytes.Buffer
+	out.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	offsets := make([]int, len(d.objects))
+	for i, object := range d.objects {
+		offsets[i] = out.Len()
+		fmt.Fprintf(&out, "%d 0 obj\n", i+1)
+		out.Write(object)
+		out.WriteString("\nendobj\n")
+	}
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(d.objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root %d 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(d.objects)+1, root, xref)
+	_, err := w.Write(out.Bytes())
+	return err
+}
+
+// Draws into the content stream of a form, in SVG units with the y axis pointing down
+type pdfCanvas struct {
+	content   bytes.Buffer
+	fonts     *fontSet
+	opacities map[uint8]string // Graphics state names by alpha
+	err       error            // First error, drawing carries on without the failed text
+}
+
+// Graphics state setting the alpha of what follows, empty for opaque colors
+func (c *pdfCanvas) alpha(col color.NRGBA) string {
+	if col.A == 255 {
+		return ""
+	}
+	name, ok := c.opacities[col.A]
+	if !ok {
+		name = fmt.Sprintf("GS%d", len(c.opacities))
+		c.opacities[col.A] = name
+	}
+	return "/" + name + " gs "
+}
+
+func (c *pdfCanvas) stateResources() string {
+	if len(c.opacities) == 0 {
+		return ""
+	}
+	var states []string
+	for alpha, name := range c.opacities {
+		opacity := num(float64(alpha) / 255)
+		states = append(states, fmt.Sprintf("/%s << /ca %s /CA %s >>", name, opacity, opacity))
+	}
+	sort.Strings(states)
+	return " /ExtGState << " + strings.Join(states, " ") + " >>"
+}
+
+func (c *pdfCanvas) path(points []point) {
+	for i, p := range points {
+		operator := "l"
+		if i == 0 {
+			operator = "m"
+		}
+		fmt.Fprintf(&c.content, "%s %s %s ", num(p.X), num(p.Y), operator)
+	}
+}
+
+func (c *pdfCanvas) fill(points []point, col color.NRGBA) {
+	if len(points) < 3 {
+		return
+	}
+	fmt.Fprintf(&c.content, "q %s%s rg ", c.alpha(col), rgb(col))
+	c.path(points)
+	c.content.WriteString("h f Q\n")
+}
+
+func (c *pdfCanvas) stroke(points []point, col color.NRGBA, width float64, dashes []float64) {
+	if len(points) < 2 {
+		return
+	}
+	var pattern []string
+	for _, dash := range dashes {
+		pattern = append(pattern, num(dash))
+	}
+	fmt.Fprintf(&c.content, "q %s%s RG %s w [%s] 0 d 1 j ", c.alpha(col), rgb(col), num(width), strings.Join(pattern, " "))
+	c.path(points)
+	c.content.WriteString("S Q\n")
+}
+
+func (c *pdfCanvas) text(run textRun) {
+	x, width, err := c.fonts.start(run)
+	if err != nil {
+		c.err = err
+		return
+	}
+	name := pdfFonts[0].name
+	for _, f := range pdfFonts {
+		if f.style == (fontStyle{Bold: run.Bold, Italic: run.Italic && !run.Bold}) {
+			name = f.name
+		}
+	}
+	// The text matrix flips the glyphs back upright
+	fmt.Fprintf(&c.content, "q %s%s rg BT /%s %s Tf 1 0 0 -1 %s %s Tm (%s) Tj ET Q\n",
+		c.alpha(run.Color), rgb(run.Color), name, num(run.Size), num(x), num(run.Y), pdfString(run.Content))
+	if run.Strike {
+		y := run.Y - run.Size*0.3
+		c.stroke([]point{{x, y}, {x + width, y}}, run.Color, math.Max(1, run.Size/15), nil)
+	}
+}
+
+func rgb(col color.NRGBA) string {
+	return fmt.Sprintf("%s %s %s", num(float64(col.R)/255), num(float64(col.G)/255), num(float64(col.B)/255))
+}
+
+// Compact number formatting, PDF doesn't allow exponents
+func num(value float64) string {
+	s := fmt.Sprintf("%.3f", value)
+	s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	if s == "-0" || s == "" {
+		return "0"
+	}
+	return s
+}
+
+// Characters outside of Latin-1 that WinAnsiEncoding has codes for
+var winAnsi = map[rune]byte{
+	'€': 0x80, '‚': 0x82, '„': 0x84, '…': 0x85, '•': 0x95, '–': 0x96, '—': 0x97,
+	'‘': 0x91, '’': 0x92, '“': 0x93, '”': 0x94, '™': 0x99,
+}
+
+// Escapes text into a literal string in WinAnsiEncoding. Arrows and minus signs
+// are spelled out, other characters the standard fonts lack are replaced with
+// question marks.
+func pdfString(text string) string {
+	var out strings.Builder
+	for _, r := range strings.NewReplacer("→", "->", "←", "<-", "−", "-").Replace(text) {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			out.WriteByte('\\')
+			out.WriteRune(r)
+		case r >= 0x20 && r < 0x7f:
+			out.WriteRune(r)
+		case r >= 0xa0 && r <= 0xff:
+			fmt.Fprintf(&out, "\\%03o", r)
+		default:
+			if b, ok := winAnsi[r]; ok {
+				fmt.Fprintf(&out, "\\%03o", b)
+			} else {
+				out.WriteByte('?')
+			}
+		}
+	}
+	return out.String()
+}
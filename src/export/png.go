@@ -0,0 +1,231 @@
+package export
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+
+	xdraw "golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+	"golang.org/x/image/vector"
+
+	svg "openplc-render/svg"
+)
+
+// Number of straight segments round line joins are approximated with
+const joinSegments = 12
+
+// Rasterizes a rendered diagram into a PNG image, scale is the number of pixels per SVG unit
+func PNG(w io.Writer, file svg.SVGFile, scale float64) error {
+	origin, width, height, err := viewBox(file)
+	if err != nil {
+		return err
+	}
+	fonts, err := newFontSet(scale)
+	if err != nil {
+		return err
+	}
+	r := &raster{
+		img:   image.NewRGBA(image.Rect(0, 0, int(math.Ceil(width*scale)), int(math.Ceil(height*scale)))),
+		scale: scale,
+		fonts: fonts,
+	}
+	draw(r, file, origin)
+	if r.err != nil {
+		return r.err
+	}
+	return png.Encode(w, r.img)
+}
+
+type raster struct {
+	img   *image.RGBA
+	scale float64
+	fonts *fontSet
+	err   error // First error, drawing carries on without the failed text
+}
+
+// Fills polygons, all of which must be wound the same way, since the rasterizer
+// cancels out overlapping areas of opposite winding
+func (r *raster) fillPolygons(polygons [][]point, c color.NRGBA) {
+	bounds := r.img.Bounds()
+	z := vector.NewRasterizer(bounds.Dx(), bounds.Dy())
+	for _, polygon := range polygons {
+		if len(polygon) < 3 {
+			continue
+		}
+		z.MoveTo(float32(polygon[0].X*r.scale), float32(polygon[0].Y*r.scale))
+		for _, p := range polygon[1:] {
+			z.LineTo(float32(p.X*r.scale), float32(p.Y*r.scale))
+		}
+		z.ClosePath()
+	}
+	z.Draw(r.img, bounds, image.NewUniform(c), image.Point{})
+}
+
+func (r *raster) fill(points []point, c color.NRGBA) {
+	r.fillPolygons([][]point{points}, c)
+}
+
+// Strokes with butt caps and round joins, made of one quad per segment
+// and one disc per join
+func (r *raster) stroke(points []point, c color.NRGBA, width float64, dashes []float64) {
+	var polygons [][]point
+	for _, piece := range dash(points, dashes) {
+		for i := 1; i < len(piece); i++ {
+			if quad := segmentQuad(piece[i-1], piece[i], width/2); quad != nil {
+				polygons = append(polygons, clockwise(quad))
+			}
+			if i < len(piece)-1 {
+				polygons = append(polygons, clockwise(disc(piece[i], width/2)))
+			}
+		}
+	}
+	r.fillPolygons(polygons, c)
+}
+
+func (r *raster) text(run textRun) {
+	face, err := r.fonts.face(run)
+	if err != nil {
+		r.err = err
+		return
+	}
+	x, width, err := r.fonts.start(run)
+	if err != nil {
+		r.err = err
+		return
+	}
+	natural, err := r.fonts.width(run)
+	if err != nil {
+		r.err = err
+		return
+	}
+	if run.Length > 0 && natural > 0 && math.Abs(run.Length-natural)*r.scale >= 0.5 {
+		r.scaledText(run, face, x, natural)
+	} else {
+		r.naturalText(run, face, x)
+	}
+	if run.Strike {
+		y := run.Y - run.Size*0.3
+		r.stroke([]point{{x, y}, {x + width, y}}, run.Color, math.Max(1, run.Size/15), nil)
+	}
+}
+
+func (r *raster) naturalText(run textRun, face font.Face, x float64) {
+	drawer := font.Drawer{
+		Dst:  r.img,
+		Src:  image.NewUniform(run.Color),
+		Face: face,
+		Dot:  fixed.Point26_6{X: fixed.Int26_6(x * r.scale * 64), Y: fixed.Int26_6(run.Y * r.scale * 64)},
+	}
+	drawer.DrawString(run.Content)
+}
+
+// Draws the run squeezed or stretched to its length, like browsers do for a
+// textLength with spacingAndGlyphs: the run is set at its natural width into a
+// mask first, which then gets scaled into place. The labels are fitted with
+// Helvetica metrics, which the embedded fonts don't share.
+func (r *raster) scaledText(run textRun, face font.Face, x, natural float64) {
+	metrics := face.Metrics()
+	ascent, descent := metrics.Ascent.Ceil(), metrics.Descent.Ceil()
+	mask := image.NewAlpha(image.Rect(0, 0, int(math.Ceil(natural*r.scale))+1, ascent+descent))
+	drawer := font.Drawer{
+		Dst:  mask,
+		Src:  image.Opaque,
+		Face: face,
+		Dot:  fixed.P(0, ascent),
+	}
+	drawer.DrawString(run.Content)
+	left := int(math.Round(x * r.scale))
+	top := int(math.Round(run.Y*r.scale)) - ascent
+	width := int(math.Round(float64(mask.Bounds().Dx()) * run.Length / natural))
+	bounds := image.Rect(left, top, left+width, top+ascent+descent)
+	scaled := image.NewAlpha(bounds)
+	xdraw.ApproxBiLinear.Scale(scaled, bounds, mask, mask.Bounds(), xdraw.Src, nil)
+	xdraw.DrawMask(r.img, bounds, image.NewUniform(run.Color), image.Point{}, scaled, bounds.Min, xdraw.Over)
+}
+
+// Rectangle covering the segment with the given half width, nil for a zero length segment
+func segmentQuad(a, b point, half float64) []point {
+	dx, dy := b.X-a.X, b.Y-a.Y
+	length := math.Hypot(dx, dy)
+	if length == 0 {
+		return nil
+	}
+	nx, ny := -dy/length*half, dx/length*half
+	return []point{
+		{a.X + nx, a.Y + ny},
+		{b.X + nx, b.Y + ny},
+		{b.X - nx, b.Y - ny},
+		{a.X - nx, a.Y - ny},
+	}
+}
+
+func disc(center point, radius float64) []point {
+	var points []point
+	for i := 0; i < joinSegments; i++ {
+		angle := 2 * math.Pi * float64(i) / joinSegments
+		points = append(points, point{center.X + radius*math.Cos(angle), center.Y + radius*math.Sin(angle)})
+	}
+	return points
+}
+
+// Returns the polygon wound clockwise on screen, reversing it if needed
+func clockwise(polygon []point) []point {
+	area := 0.0
+	for i, p := range polygon {
+		q := polygon[(i+1)%len(polygon)]
+		area += p.X*q.Y - q.X*p.Y
+	}
+	if area >= 0 {
+		return polygon
+	}
+	reversed := make([]point, len(polygon))
+	for i, p := range polygon {
+		reversed[len(polygon)-1-i] = p
+	}
+	return reversed
+}
+
+// Splits a polyline into the pieces a dash pattern leaves visible,
+// the whole polyline without one
+func dash(points []point, dashes []float64) [][]point {
+	if len(dashes) == 0 {
+		return [][]point{points}
+	}
+	var pieces [][]point
+	var current []point
+	index, left, on := 0, dashes[0], true
+	if on && len(points) > 0 {
+		current = []point{points[0]}
+	}
+	for i := 1; i < len(points); i++ {
+		a, b := points[i-1], points[i]
+		length := math.Hypot(b.X-a.X, b.Y-a.Y)
+		done := 0.0
+		for length-done > left {
+			done += left
+			t := done / length
+			p := point{a.X + (b.X-a.X)*t, a.Y + (b.Y-a.Y)*t}
+			if on {
+				pieces = append(pieces, append(current, p))
+				current = nil
+			} else {
+				current = []point{p}
+			}
+			on = !on
+			index = (index + 1) % len(dashes)
+			left = dashes[index]
+		}
+		left -= length - done
+		if on {
+			current = append(current, b)
+		}
+	}
+	if on && len(current) > 1 {
+		pieces = append(pieces, current)
+	}
+	return pieces
+}
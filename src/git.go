@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Commit metadata as reported by git log
+type commitInfo struct {
+	SHA     string
+	Author  string
+	Email   string
+	Date    string
+	Subject string
+	Path    string // Path of the tracked file at this commit, relative to the repo root
+	OldPath string // Path of the file before this commit, differs from Path if the commit renamed it
+}
+
+// Field and record separators for git's --format output, chosen so they
+// never clash with anything in commit subjects or author names
+const (
+	gitFieldSep  = "\x1f"
+	gitRecordSep = "\x1e"
+	gitLogFormat = "%x1e%H%x1f%an%x1f%ae%x1f%aI%x1f%s"
+)
+
+func runGit(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf(
+			"git %s failed: %w (%s)",
+			args[0],
+			err,
+			strings.TrimSpace(stderr.String()),
+		)
+	}
+	return stdout.String(), nil
+}
+
+// Returns the contents of a file (path relative to the repo root) at the given ref
+func getFileContentsAtPath(repoPath, relPath, ref string) ([]byte, error) {
+	out, err := runGit(repoPath, "show", fmt.Sprintf("%s:%s", ref, relPath))
+	if err != nil {
+		return nil, err
+	}
+	return []byte(out), nil
+}
+
+// Whether the file (path relative to the repo root) exists at the given ref
+func fileExistsAtRef(repoPath, relPath, ref string) (bool, error) {
+	out, err := runGit(repoPath, "ls-tree", "--name-only", ref, "--", relPath)
+	if err != nil {
+		return false, err
+	}
+	return strings.TrimSpace(out) != "", nil
+}
+
+// Lists commits up to until that touched the file, oldest first, following renames.
+// If since is not empty, only commits after it are listed.
+func getFileHistory(repoPath, relPath, since, until string) ([]commitInfo, error) {
+	args := []string{"log", "--follow", "--name-status", "--format=" + gitLogFormat}
+	if since != "" {
+		args = append(args, since+".."+until)
+	} else {
+		args = append(args, until)
+	}
+	args = append(args, "--", relPath)
+	out, err := runGit(repoPath, args...)
+	if err != nil {
+		return nil, err
+	}
+	var commits []commitInfo
+	for _, record := range strings.Split(out, gitRecordSep) {
+		if strings.TrimSpace(record) == "" {
+			continue
+		}
+		lines := strings.Split(strings.TrimSpace(record), "\n")
+		commit, err := parseCommitHeader(lines[0])
+		if err != nil {
+			return nil, err
+		}
+		// With --name-status the status and path follow the header after an
+		// empty line, renames and copies list the old path before the new one
+		for _, line := range lines[1:] {
+			fields := strings.Split(strings.TrimSpace(line), "\t")
+			if len(fields) < 2 {
+				continue
+			}
+			commit.Path = fields[len(fields)-1]
+			commit.OldPath = fields[1]
+		}
+		if commit.Path == "" {
+			commit.Path = relPath
+		}
+		if commit.OldPath == "" {
+			commit.OldPath = commit.Path
+		}
+		commits = append(commits, commit)
+	}
+	// git log lists newest first
+	for i, j := 0, len(commits)-1; i < j; i, j = i+1, j-1 {
+		commits[i], commits[j] = commits[j], commits[i]
+	}
+	return commits, nil
+}
+
+// Returns metadata of a single commit
+func getCommitInfo(repoPath, ref string) (commitInfo, error) {
+	out, err := runGit(repoPath, "show", "-s", "--format="+gitLogFormat, ref)
+	if err != nil {
+		return commitInfo{}, err
+	}
+	return parseCommitHeader(strings.TrimSpace(strings.TrimPrefix(out, gitRecordSep)))
+}
+
+func parseCommitHeader(header string) (commitInfo, error) {
+	fields := strings.Split(strings.TrimPrefix(header, gitRecordSep), gitFieldSep)
+	if len(fields) != 5 {
+		return commitInfo{}, fmt.Errorf("unexpected git log output: %q", header)
+	}
+	return commitInfo{
+		SHA:     fields[0],
+		Author:  fields[1],
+		Email:   fields[2],
+		Date:    fields[3],
+		Subject: fields[4],
+	}, nil
+}
+
+// Short form of the commit hash for display purposes
+func (c commitInfo) ShortSHA() string {
+	if len(c.SHA) > 8 {
+		return c.SHA[:8]
+	}
+	return c.SHA
+}
+
+// Reports whether the name refers to a local or a remote-tracking branch
+func isBranchName(repoPath, name string) bool {
+	for _, prefix := range []string{"refs/heads/", "refs/remotes/"} {
+		if _, err := runGit(repoPath, "show-ref", "--verify", "--quiet", prefix+name); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the best common ancestor of two refs
+func getMergeBase(repoPath, ours, theirs string) (string, error) {
+	out, err := runGit(repoPath, "merge-base", ours, theirs)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(out), nil
+}
+
+// Line-based three-way merge of arbitrary text, returns the merged text with
+// conflict markers where needed and whether it merged cleanly
+func mergeText(base, ours, theirs []byte) ([]byte, bool, error) {
+	dir, err := os.MkdirTemp("", "difflad-merge-*")
+	if err != nil {
+		return nil, false, err
+	}
+	defer os.RemoveAll(dir)
+	paths := []string{filepath.Join(dir, "ours"), filepath.Join(dir, "base"), filepath.Join(dir, "theirs")}
+	for i, contents := range [][]byte{ours, base, theirs} {
+		if err := os.WriteFile(paths[i], contents, 0644); err != nil {
+			return nil, false, err
+		}
+	}
+	cmd := exec.Command("git", "merge-file", "-p", "-L", "ours", "-L", "base", "-L", "theirs", paths[0], paths[1], paths[2])
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	// A positive exit code is the number of conflicts, anything else is a failure
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() > 0 && exitErr.ExitCode() < 128 {
+		return stdout.Bytes(), false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf(
+			"git merge-file failed: %w (%s)",
+			err,
+			strings.TrimSpace(stderr.String()),
+		)
+	}
+	return stdout.Bytes(), true, nil
+}
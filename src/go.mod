@@ -1,3 +1,13 @@
 module openplc-render
 
 go 1.22.0
+
+require (
+	github.com/BurntSushi/toml v1.6.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require (
+	golang.org/x/image v0.18.0
+	golang.org/x/text v0.16.0 // indirect
+)
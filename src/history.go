@@ -0,0 +1,232 @@
+package main
+
+import (
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"html/template"
+	"log"
+	"os"
+	"path/filepath"
+
+	elements "openplc-render/elements"
+	svg "openplc-render/svg"
+)
+
+// One step of the timeline: the change a single commit made to the POU
+type historyStep struct {
+	Commit   commitInfo
+	Previous commitInfo
+	Old      template.HTML
+	New      template.HTML
+	Changes  []elements.RungChange
+}
+
+type historyPage struct {
+	File  string
+	POU   string
+	Since string
+	Steps []historyStep
+}
+
+var historyTemplate = template.Must(template.New("history").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.POU}} history</title>
+<style>
+body { font-family: arial, sans-serif; margin: 2em; }
+.step { border-top: 1px solid #888; padding: 1em 0; }
+.meta { color: #555; margin-bottom: 0.5em; }
+.diagrams { display: flex; gap: 1em; }
+.diagrams > div { flex: 1; }
+.diagrams svg { width: 100%; height: auto; }
+</style>
+</head>
+<body>
+<h1>{{.POU}}</h1>
+<p>{{.File}}{{if .Since}}, changes since {{.Since}}{{end}}, {{len .Steps}} step(s), press <kbd>n</kbd> and <kbd>p</kbd> to jump to the next and previous change</p>
+{{range .Steps}}
+<div class="step">
+<h2><code>{{.Commit.ShortSHA}}</code> {{.Commit.Subject}}</h2>
+<div class="meta">{{.Commit.Author}} &lt;{{.Commit.Email}}&gt;, {{.Commit.Date}}, compared to <code>{{.Previous.ShortSHA}}</code></div>
+<ul>{{range .Changes}}<li>{{.}}</li>{{end}}</ul>
+<div class="diagrams">
+<div>{{.Old}}</div>
+<div>{{.New}}</div>
+</div>
+</div>
+{{end}}
+<script>
+// Scrolls to the next or previous changed rung of any diagram on the page
+document.addEventListener("keydown", function (event) {
+	if (event.ctrlKey || event.metaKey || event.altKey) return;
+	var step = event.key === "n" ? 1 : event.key === "p" ? -1 : 0;
+	if (step === 0) return;
+	var middle = window.scrollY + window.innerHeight / 2;
+	var stops = Array.prototype.map.call(document.querySelectorAll(".change-marker"), function (marker) {
+		var matrix = marker.ownerSVGElement.getScreenCTM();
+		return matrix.f + matrix.d * (marker.y.baseVal.value + marker.height.baseVal.value / 2) + window.scrollY;
+	}).filter(function (y) {
+		return step > 0 ? y > middle + 1 : y < middle - 1;
+	}).sort(function (a, b) { return (a - b) * step; });
+	if (stops.length > 0) window.scrollTo(window.scrollX, stops[0] - window.innerHeight / 2);
+});
+</script>
+</body>
+</html>
+`))
+
+func runHistory(args []string) error {
+	flags := flag.NewFlagSet("history", flag.ExitOnError)
+	filePath := flags.String("file", "", "Path to file inside the git repo")
+	pouName := flags.String("pou", "", "Which POU to render")
+	since := flags.String("since", "", "Only walk commits after this ref, the whole file history otherwise")
+	outputFolder := flags.String("output", "", "Folder for the output .html file, will put it in a system temporary folder otherwise")
+	style := flags.String("style", "dark", "Diagram style, name of a built-in or loaded theme, dark by default")
+	themeFile := flags.String("theme", "", "JSON, YAML or TOML file with a custom theme, used instead of --style")
+	flags.Parse(args)
+
+	if *filePath == "" {
+		return fmt.Errorf("error: file path not provided")
+	}
+	if *pouName == "" {
+		return fmt.Errorf("error: pou name not provided")
+	}
+	if err := loadThemeFile(*themeFile, style); err != nil {
+		return err
+	}
+	if err := checkStyle(*style); err != nil {
+		return err
+	}
+	folder, err := prepareOutputFolder(*outputFolder)
+	if err != nil {
+		return err
+	}
+	log.Printf("output folder path: %s", folder)
+
+	absPath, err := filepath.Abs(*filePath)
+	if err != nil {
+		return err
+	}
+	repoPath, err := getRepoRoot(absPath)
+	if err != nil {
+		return err
+	}
+	relPath, err := filepath.Rel(repoPath, absPath)
+	if err != nil {
+		return err
+	}
+	commits, err := getFileHistory(repoPath, filepath.ToSlash(relPath), *since, "HEAD")
+	if err != nil {
+		return err
+	}
+	// The commit we start from is the baseline of the first step
+	if *since != "" {
+		base, err := getCommitInfo(repoPath, *since)
+		if err != nil {
+			return err
+		}
+		base.Path = filepath.ToSlash(relPath)
+		if len(commits) > 0 {
+			// The first commit may have renamed the file, the base still has the old name
+			base.Path = commits[0].OldPath
+		}
+		commits = append([]commitInfo{base}, commits...)
+	}
+	log.Printf("found %d commits touching %s", len(commits), relPath)
+
+	steps, err := renderHistorySteps(repoPath, *pouName, *style, commits)
+	if err != nil {
+		return err
+	}
+	page := historyPage{
+		File:  filepath.ToSlash(relPath),
+		POU:   *pouName,
+		Since: *since,
+		Steps: steps,
+	}
+	path := filepath.Join(folder, "history.html")
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := historyTemplate.Execute(f, page); err != nil {
+		return err
+	}
+	log.Printf("timeline with %d steps written to %s", len(steps), path)
+	return openOutputFolder(folder)
+}
+
+// Renders every consecutive pair of commits where the POU actually changed
+func renderHistorySteps(repoPath, pouName, style string, commits []commitInfo) ([]historyStep, error) {
+	var steps []historyStep
+	for i := 1; i < len(commits); i++ {
+		prev, curr := commits[i-1], commits[i]
+		oldPou, oldFound, err := loadPOUAtCommit(repoPath, pouName, prev)
+		if err != nil {
+			return nil, err
+		}
+		newPou, newFound, err := loadPOUAtCommit(repoPath, pouName, curr)
+		if err != nil {
+			return nil, err
+		}
+		if !oldFound && !newFound {
+			continue
+		}
+		oldPou.CalculateDiff(&newPou)
+		if !oldPou.HasChanges() && !newPou.HasChanges() {
+			log.Printf("skipping %s, %s unchanged", curr.ShortSHA(), pouName)
+			continue
+		}
+		// All steps end up on one page, so element IDs need to be unique across them
+		oldRenderer, newRenderer := svg.NewRenderer(style), svg.NewRenderer(style)
+		oldRenderer.IDPrefix = fmt.Sprintf("step%d-old-", len(steps)+1)
+		newRenderer.IDPrefix = fmt.Sprintf("step%d-new-", len(steps)+1)
+		// Change markers of the diagrams are the stops of the page navigation
+		oldRenderer.Navigation, newRenderer.Navigation = true, true
+		oldSVG, err := marshalSVG(oldRenderer.RenderPOU(oldPou, svg.Annotations{}))
+		if err != nil {
+			return nil, err
+		}
+		newSVG, err := marshalSVG(newRenderer.RenderPOU(newPou, svg.Annotations{}))
+		if err != nil {
+			return nil, err
+		}
+		steps = append(steps, historyStep{
+			Commit:   curr,
+			Previous: prev,
+			Old:      oldSVG,
+			New:      newSVG,
+			Changes:  oldPou.RungChanges(&newPou),
+		})
+	}
+	return steps, nil
+}
+
+func loadPOUAtCommit(repoPath, pouName string, commit commitInfo) (elements.POU, bool, error) {
+	exists, err := fileExistsAtRef(repoPath, commit.Path, commit.SHA)
+	if err != nil {
+		return elements.POU{}, false, err
+	}
+	if !exists {
+		// The commit that deleted the file is part of its history too
+		log.Printf("file %s not available at %s", commit.Path, commit.ShortSHA())
+		return elements.POU{Name: pouName, Elements: make(map[string]*elements.Element)}, false, nil
+	}
+	contents, err := getFileContentsAtPath(repoPath, commit.Path, commit.SHA)
+	if err != nil {
+		return elements.POU{}, false, err
+	}
+	return parsePOUFromContents(contents, pouName)
+}
+
+// Serializes an SVG file for inlining into an HTML page
+func marshalSVG(file svg.SVGFile) (template.HTML, error) {
+	content, err := xml.Marshal(file)
+	if err != nil {
+		return "", err
+	}
+	return template.HTML(content), nil
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	lint "openplc-render/lint"
+	svg "openplc-render/svg"
+	plcxml "openplc-render/xml"
+)
+
+// Lints a version of the file, returns whether it's free of errors
+func runLint(args []string) (bool, error) {
+	flags := flag.NewFlagSet("lint", flag.ExitOnError)
+	filePath := flags.String("file", "", "Path to file inside the git repo")
+	ref := flags.String("ref", "HEAD", "Version of the file to lint")
+	var pouNames refList
+	flags.Var(&pouNames, "pou", "Only report findings in this POU (repeatable), all POUs with a ladder diagram otherwise")
+	configFile := flags.String("config", "", "JSON, YAML or TOML file with the severities of rules")
+	var ruleOverrides refList
+	flags.Var(&ruleOverrides, "rule", "Severity of a rule as id=severity, with severity one of off, note, warning or error (repeatable, overrides --config)")
+	format := flags.String("format", "text", "Output format: text or sarif")
+	output := flags.String("output", "", "File to write the findings to, standard output otherwise")
+	listRules := flags.Bool("list-rules", false, "List the rules with their default severities and exit")
+	flags.Parse(args)
+
+	if *listRules {
+		for _, rule := range lint.Rules {
+			fmt.Printf("%-20s %-8s %s\n", rule.ID, rule.Severity, rule.Description)
+		}
+		return true, nil
+	}
+	if *filePath == "" {
+		return false, fmt.Errorf("error: file path not provided")
+	}
+	if *format != "text" && *format != "sarif" {
+		return false, fmt.Errorf("error: unsupported output format %s", *format)
+	}
+	severities, err := lintSeverities(*configFile, ruleOverrides)
+	if err != nil {
+		return false, err
+	}
+	findings, contents, err := lintAtRef(*filePath, *ref, severities)
+	if err != nil {
+		return false, err
+	}
+	findings = filterFindings(findings, pouNames)
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return false, err
+		}
+		defer f.Close()
+		w = f
+	}
+	line := findingLine(contents)
+	if *format == "sarif" {
+		uri, err := repoRelativePath(*filePath)
+		if err != nil {
+			return false, err
+		}
+		err = lint.WriteSARIF(w, findings, severities, uri, line)
+	} else {
+		err = lint.WriteText(w, findings, *filePath, line)
+	}
+	if err != nil {
+		return false, err
+	}
+	return !hasErrors(findings), nil
+}
+
+// Lints a version of the file, returns the findings and the raw contents they're located in
+func lintAtRef(filePath, ref string, severities map[string]lint.Severity) ([]lint.Finding, []byte, error) {
+	contents, err := getFileContentsFromGit(filePath, ref)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error fetching file contents via git: %w", err)
+	}
+	var project plcxml.Project
+	if err := xml.Unmarshal(contents, &project); err != nil {
+		return nil, nil, fmt.Errorf("error parsing XML: %w", err)
+	}
+	return lint.Run(lint.NewProgram(&project), severities), contents, nil
+}
+
+func hasErrors(findings []lint.Finding) bool {
+	for _, finding := range findings {
+		if finding.Severity == lint.SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// Highlight colors of new lint findings in a rendered diff
+var severity_color = map[lint.Severity]string{
+	lint.SeverityError:   "#d62728",
+	lint.SeverityWarning: "#bcbd22",
+	lint.SeverityNote:    "#17becf",
+}
+
+// Lints both versions of the file and prints the findings of the given POUs
+// that the new version introduces, which are returned by POU
+func lintDiff(filePath string, pouNames []string, old_ref, new_ref string, severities map[string]lint.Severity) (map[string][]lint.Finding, error) {
+	old_findings, _, err := lintAtRef(filePath, old_ref, severities)
+	if err != nil {
+		return nil, err
+	}
+	new_findings, contents, err := lintAtRef(filePath, new_ref, severities)
+	if err != nil {
+		return nil, err
+	}
+	added := filterFindings(lint.NewFindings(old_findings, new_findings), pouNames)
+	log.Printf("%d lint finding(s) at %s, %d of them new since %s", len(new_findings), new_ref, len(added), old_ref)
+	if err := lint.WriteText(os.Stdout, added, filePath, findingLine(contents)); err != nil {
+		return nil, err
+	}
+	by_pou := make(map[string][]lint.Finding)
+	for _, finding := range added {
+		by_pou[finding.POU] = append(by_pou[finding.POU], finding)
+	}
+	return by_pou, nil
+}
+
+// Highlights the elements of the new lint findings of the POU, with the findings
+// as tooltips, without touching the maps of the given annotations. The highlights
+// replace others, like the ones of three-way changes, since they point at what
+// needs fixing.
+func (o renderOptions) withFindings(annotations svg.Annotations, pouName string) svg.Annotations {
+	findings := o.Findings[pouName]
+	if len(findings) == 0 {
+		return annotations
+	}
+	flagged := make(map[string]svg.Annotation)
+	for uid, annotation := range annotations.Elements {
+		flagged[uid] = annotation
+	}
+	worst := make(map[string]lint.Severity)
+	present := make(map[lint.Severity]bool)
+	for _, finding := range findings {
+		present[finding.Severity] = true
+		for _, uid := range finding.Elements {
+			annotation := flagged[uid]
+			if annotation.Title != "" {
+				annotation.Title += "\n"
+			}
+			annotation.Title += fmt.Sprintf("New %s: %s [%s]", finding.Severity, finding.Message, finding.Rule)
+			worst[uid] = max(worst[uid], finding.Severity)
+			annotation.Highlight = severity_color[worst[uid]]
+			flagged[uid] = annotation
+		}
+	}
+	annotations.Elements = flagged
+	legend := append([]svg.LegendEntry(nil), annotations.Legend...)
+	for _, severity := range []lint.Severity{lint.SeverityError, lint.SeverityWarning, lint.SeverityNote} {
+		if present[severity] {
+			legend = append(legend, svg.LegendEntry{Color: severity_color[severity], Label: "New lint " + severity.String()})
+		}
+	}
+	annotations.Legend = legend
+	return annotations
+}
+
+// Severities of the rules: the defaults, overridden by the config file, overridden by id=severity pairs
+func lintSeverities(configFile string, overrides []string) (map[string]lint.Severity, error) {
+	config := lint.Config{}
+	if configFile != "" {
+		var err error
+		if config, err = lint.LoadConfig(configFile); err != nil {
+			return nil, err
+		}
+	}
+	if config.Rules == nil {
+		config.Rules = make(map[string]string)
+	}
+	for _, override := range overrides {
+		id, severity, ok := strings.Cut(override, "=")
+		if !ok {
+			return nil, fmt.Errorf("error: rule severity %q is not in the form id=severity", override)
+		}
+		config.Rules[id] = severity
+	}
+	return config.Severities()
+}
+
+// Findings in the given POUs, all of them if there are none
+func filterFindings(findings []lint.Finding, pouNames []string) []lint.Finding {
+	if len(pouNames) == 0 {
+		return findings
+	}
+	var filtered []lint.Finding
+	for _, finding := range findings {
+		for _, name := range pouNames {
+			if finding.POU == name {
+				filtered = append(filtered, finding)
+			}
+		}
+	}
+	return filtered
+}
+
+// Path of the file relative to the root of its repository, with forward slashes
+func repoRelativePath(filePath string) (string, error) {
+	absPath, err := filepath.Abs(filePath)
+	if err != nil {
+		return "", err
+	}
+	repoPath, err := getRepoRoot(absPath)
+	if err != nil {
+		return "", err
+	}
+	relPath, err := filepath.Rel(repoPath, absPath)
+	if err != nil {
+		return "", err
+	}
+	return filepath.ToSlash(relPath), nil
+}
+
+// Looks up the line of the element, the variable declaration or the POU a
+// finding is about in the raw project XML
+func findingLine(contents []byte) lint.LineFunc {
+	return func(finding lint.Finding) int {
+		start := regexp.MustCompile(`<pou\s+name="` + regexp.QuoteMeta(finding.POU) + `"`).FindIndex(contents)
+		if start == nil {
+			return 0
+		}
+		pou := contents[start[0]:]
+		if end := bytes.Index(pou, []byte("</pou>")); end >= 0 {
+			pou = pou[:end]
+		}
+		offset := 0
+		var target *regexp.Regexp
+		switch {
+		case finding.Element() != "":
+			target = regexp.MustCompile(`localId="` + regexp.QuoteMeta(finding.Element()) + `"`)
+		case finding.Variable != "":
+			target = regexp.MustCompile(`<variable\s+name="` + regexp.QuoteMeta(finding.Variable) + `"`)
+		}
+		if target != nil {
+			if match := target.FindIndex(pou); match != nil {
+				offset = match[0]
+			}
+		}
+		return bytes.Count(contents[:start[0]+offset], []byte("\n")) + 1
+	}
+}
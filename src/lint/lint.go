@@ -0,0 +1,251 @@
+// Checks ladder diagrams for common mistakes, like coils driving the same
+// variable twice or pins left unconnected. Every check is a rule with an ID and a
+// severity, which can be adjusted or turned off by a config file.
+package lint
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+
+	elements "openplc-render/elements"
+	plcxml "openplc-render/xml"
+)
+
+type Severity int
+
+const (
+	SeverityOff Severity = iota
+	SeverityNote
+	SeverityWarning
+	SeverityError
+)
+
+var Severities = map[string]Severity{
+	"off":     SeverityOff,
+	"note":    SeverityNote,
+	"warning": SeverityWarning,
+	"error":   SeverityError,
+}
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityNote:
+		return "note"
+	case SeverityWarning:
+		return "warning"
+	case SeverityError:
+		return "error"
+	}
+	return "off"
+}
+
+// Declared variable of a POU or a configuration
+type Variable struct {
+	Name    string
+	Section string // Declaration section, like localVars, inputVars or globalVars
+	Address string // Like %IX0.0, empty for unlocated variables
+}
+
+// The ladder diagrams of a project along with the variables they can use
+type Program struct {
+	POUs      []elements.POU
+	Usages    map[string][]elements.Usage // By POU name
+	Variables map[string][]Variable       // By POU name, global variables under ""
+}
+
+// Parses every POU with a ladder diagram and collects the variable declarations
+func NewProgram(project *plcxml.Project) *Program {
+	program := &Program{Usages: make(map[string][]elements.Usage), Variables: make(map[string][]Variable)}
+	declare := func(pou string, section string, vars plcxml.LocalVars) {
+		for _, v := range vars.Variable {
+			program.Variables[pou] = append(program.Variables[pou], Variable{Name: v.Name, Section: section, Address: v.Address})
+		}
+	}
+	for _, name := range project.GetLDPouNames() {
+		pou, err := project.GetPouByName(name)
+		if err != nil {
+			continue
+		}
+		var parsed elements.POU
+		parsed.Parse(pou)
+		program.POUs = append(program.POUs, parsed)
+		program.Usages[name] = parsed.Usages()
+		declare(name, "localVars", pou.Interface.LocalVars)
+		declare(name, "tempVars", pou.Interface.TempVars)
+		declare(name, "inputVars", pou.Interface.InputVars)
+		declare(name, "outputVars", pou.Interface.OutputVars)
+		declare(name, "inOutVars", pou.Interface.InOutVars)
+		declare(name, "externalVars", pou.Interface.ExternalVars)
+	}
+	for _, configuration := range project.Instances.Configurations.Configuration {
+		declare("", "globalVars", configuration.GlobalVars)
+		for _, resource := range configuration.Resource {
+			declare("", "globalVars", resource.GlobalVars)
+		}
+	}
+	return program
+}
+
+// Declaration of a variable as seen from the POU, local ones shadowing globals
+func (p *Program) Lookup(pou, name string) (Variable, bool) {
+	for _, scope := range []string{pou, ""} {
+		for _, v := range p.Variables[scope] {
+			if strings.EqualFold(v.Name, name) {
+				return v, true
+			}
+		}
+	}
+	return Variable{}, false
+}
+
+type Finding struct {
+	Rule     string
+	Severity Severity
+	POU      string
+	Rung     int      // Zero outside of rungs
+	Elements []string // UIDs, the finding is located at the first one, none for findings about the POU
+	Variable string   // Variable the finding is about, if any
+	Pin      string   // Pin of the element the finding is about, if any
+	Message  string
+}
+
+type Rule struct {
+	ID          string
+	Description string
+	Severity    Severity // Default severity
+	Check       func(program *Program, pou *elements.POU) []Finding
+}
+
+// Severity of every rule by ID, read from a JSON, YAML or TOML file
+type Config struct {
+	Rules map[string]string `json:"rules" yaml:"rules" toml:"rules"`
+}
+
+// Loads a config from a JSON, YAML or TOML file (by extension)
+func LoadConfig(path string) (Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, fmt.Errorf("error: could not read lint config file: %w", err)
+	}
+	var config Config
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		err = json.Unmarshal(data, &config)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &config)
+	case ".toml":
+		err = toml.Unmarshal(data, &config)
+	default:
+		return Config{}, fmt.Errorf("error: unsupported lint config file format: %s", path)
+	}
+	if err != nil {
+		return Config{}, fmt.Errorf("error: could not parse lint config file %s: %w", path, err)
+	}
+	return config, nil
+}
+
+// Severities of all rules, the defaults overridden by the config
+func (c Config) Severities() (map[string]Severity, error) {
+	severities := make(map[string]Severity)
+	for _, rule := range Rules {
+		severities[rule.ID] = rule.Severity
+	}
+	for id, name := range c.Rules {
+		if _, ok := severities[id]; !ok {
+			return nil, fmt.Errorf("error: unknown lint rule %s", id)
+		}
+		severity, ok := Severities[strings.ToLower(name)]
+		if !ok {
+			return nil, fmt.Errorf("error: unknown severity %s of lint rule %s", name, id)
+		}
+		severities[id] = severity
+	}
+	return severities, nil
+}
+
+// Runs all rules that aren't turned off over every POU of the program. Findings
+// are sorted by POU, rung and element.
+func Run(program *Program, severities map[string]Severity) []Finding {
+	var findings []Finding
+	for i := range program.POUs {
+		pou := &program.POUs[i]
+		for _, rule := range Rules {
+			severity := severities[rule.ID]
+			if severity == SeverityOff {
+				continue
+			}
+			for _, finding := range rule.Check(program, pou) {
+				finding.Rule = rule.ID
+				finding.Severity = severity
+				finding.POU = pou.Name
+				if rung := pou.RungOf(finding.Element()); rung != nil {
+					finding.Rung = rung.Number
+				}
+				findings = append(findings, finding)
+			}
+		}
+	}
+	sort.SliceStable(findings, func(i, j int) bool {
+		a, b := findings[i], findings[j]
+		if a.POU != b.POU {
+			return a.POU < b.POU
+		}
+		if a.Rung != b.Rung {
+			return a.Rung < b.Rung
+		}
+		return elements.UIDLess(a.Element(), b.Element())
+	})
+	return findings
+}
+
+// UID of the element the finding is located at, empty for findings about the POU
+func (f Finding) Element() string {
+	if len(f.Elements) == 0 {
+		return ""
+	}
+	return f.Elements[0]
+}
+
+// Where the finding is, like "main, rung 2, element #13"
+func (f Finding) Location() string {
+	parts := []string{f.POU}
+	if f.Rung != 0 {
+		parts = append(parts, fmt.Sprintf("rung %d", f.Rung))
+	}
+	if uid := f.Element(); uid != "" {
+		parts = append(parts, "element #"+uid)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Identifies the finding across versions, elements keep their UIDs when edited
+func (f Finding) key() string {
+	uids := append([]string(nil), f.Elements...)
+	sort.Strings(uids)
+	return strings.Join([]string{f.Rule, f.POU, f.Variable, f.Pin, strings.Join(uids, " ")}, "\x00")
+}
+
+// Findings of the new version that the old version doesn't have. Findings that
+// occur several times only count as new beyond the number of old occurrences.
+func NewFindings(old_findings, new_findings []Finding) []Finding {
+	counts := make(map[string]int)
+	for _, finding := range old_findings {
+		counts[finding.key()]++
+	}
+	var added []Finding
+	for _, finding := range new_findings {
+		if counts[finding.key()] > 0 {
+			counts[finding.key()]--
+			continue
+		}
+		added = append(added, finding)
+	}
+	return added
+}
@@ -0,0 +1,147 @@
+package lint
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Line of the file a finding is located at, zero if unknown
+type LineFunc func(finding Finding) int
+
+// Writes one finding per line, like a compiler, followed by a count of them
+func WriteText(w io.Writer, findings []Finding, file string, line LineFunc) error {
+	counts := make(map[Severity]int)
+	for _, finding := range findings {
+		counts[finding.Severity]++
+		location := file
+		if n := line(finding); n > 0 {
+			location = fmt.Sprintf("%s:%d", file, n)
+		}
+		if _, err := fmt.Fprintf(w, "%s: %s: %s: %s [%s]\n", location, finding.Location(), finding.Severity, finding.Message, finding.Rule); err != nil {
+			return err
+		}
+	}
+	var parts []string
+	for _, severity := range []Severity{SeverityError, SeverityWarning, SeverityNote} {
+		if counts[severity] > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s(s)", counts[severity], severity))
+		}
+	}
+	summary := "no findings"
+	if len(parts) > 0 {
+		summary = fmt.Sprintf("%d finding(s): %s", len(findings), strings.Join(parts, ", "))
+	}
+	_, err := fmt.Fprintln(w, summary)
+	return err
+}
+
+type sarifLog struct {
+	Version string     `json:"version"`
+	Schema  string     `json:"$schema"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name  string      `json:"name"`
+	Rules []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID                   string       `json:"id"`
+	ShortDescription     sarifMessage `json:"shortDescription"`
+	DefaultConfiguration struct {
+		Level string `json:"level"`
+	} `json:"defaultConfiguration"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	RuleIndex int             `json:"ruleIndex"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation  `json:"physicalLocation"`
+	LogicalLocations []sarifLogicalLocation `json:"logicalLocations"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation struct {
+		URI string `json:"uri"`
+	} `json:"artifactLocation"`
+	Region *sarifRegion `json:"region,omitempty"`
+}
+
+type sarifRegion struct {
+	StartLine int `json:"startLine"`
+}
+
+type sarifLogicalLocation struct {
+	Name               string `json:"name"`
+	FullyQualifiedName string `json:"fullyQualifiedName"`
+	Kind               string `json:"kind"`
+}
+
+// Writes the findings as a SARIF 2.1.0 log for code scanning tools, with the
+// rules that weren't turned off. Findings are located in the file at the given
+// URI, relative to the root of the repository.
+func WriteSARIF(w io.Writer, findings []Finding, severities map[string]Severity, uri string, line LineFunc) error {
+	run := sarifRun{
+		Tool:    sarifTool{Driver: sarifDriver{Name: "difflad"}},
+		Results: []sarifResult{},
+	}
+	indices := make(map[string]int)
+	for _, rule := range Rules {
+		if severities[rule.ID] == SeverityOff {
+			continue
+		}
+		sarif_rule := sarifRule{ID: rule.ID, ShortDescription: sarifMessage{Text: rule.Description}}
+		sarif_rule.DefaultConfiguration.Level = severities[rule.ID].String()
+		indices[rule.ID] = len(run.Tool.Driver.Rules)
+		run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, sarif_rule)
+	}
+	for _, finding := range findings {
+		var location sarifLocation
+		location.PhysicalLocation.ArtifactLocation.URI = uri
+		if n := line(finding); n > 0 {
+			location.PhysicalLocation.Region = &sarifRegion{StartLine: n}
+		}
+		logical := sarifLogicalLocation{Name: finding.POU, FullyQualifiedName: finding.POU, Kind: "module"}
+		if uid := finding.Element(); uid != "" {
+			logical = sarifLogicalLocation{Name: "#" + uid, FullyQualifiedName: finding.POU + "/#" + uid, Kind: "object"}
+		}
+		location.LogicalLocations = []sarifLogicalLocation{logical}
+		run.Results = append(run.Results, sarifResult{
+			RuleID:    finding.Rule,
+			RuleIndex: indices[finding.Rule],
+			Level:     finding.Severity.String(),
+			Message:   sarifMessage{Text: finding.Location() + ": " + finding.Message},
+			Locations: []sarifLocation{location},
+		})
+	}
+	log := sarifLog{
+		Version: "2.1.0",
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Runs:    []sarifRun{run},
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(log)
+}
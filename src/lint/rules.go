@@ -0,0 +1,352 @@
+package lint
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	elements "openplc-render/elements"
+)
+
+var Rules = []Rule{
+	{
+		ID:          "double-coil",
+		Description: "A variable is driven by more than one coil that isn't a set or reset coil, so all but the last one have no effect",
+		Severity:    SeverityWarning,
+		Check:       checkDoubleCoils,
+	},
+	{
+		ID:          "unconnected-pin",
+		Description: "A pin of a contact, coil, block, variable, connector or continuation isn't wired to anything",
+		Severity:    SeverityWarning,
+		Check:       checkUnconnectedPins,
+	},
+	{
+		ID:          "dangling-reference",
+		Description: "A connection refers to a localId that no element of the POU has",
+		Severity:    SeverityError,
+		Check:       checkDanglingReferences,
+	},
+	{
+		ID:          "never-written",
+		Description: "A contact reads a variable that no ladder diagram writes and that isn't an input",
+		Severity:    SeverityWarning,
+		Check:       checkNeverWritten,
+	},
+	{
+		ID:          "set-without-reset",
+		Description: "A variable is set by a set coil, but no reset coil ever resets it",
+		Severity:    SeverityWarning,
+		Check:       checkSetWithoutReset,
+	},
+	{
+		ID:          "outside-rails",
+		Description: "An element lies outside of the area between the power rails",
+		Severity:    SeverityWarning,
+		Check:       checkOutsideRails,
+	},
+	{
+		ID:          "unused-variable",
+		Description: "A local variable is declared but not used by any element",
+		Severity:    SeverityNote,
+		Check:       checkUnusedVariables,
+	},
+}
+
+// Elements of the POU in the order of their UIDs, so that findings come out the same every run
+func sortedElements(pou *elements.POU) []*elements.Element {
+	var sorted []*elements.Element
+	for _, elem := range pou.Elements {
+		sorted = append(sorted, elem)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return elements.UIDLess(sorted[i].UID, sorted[j].UID)
+	})
+	return sorted
+}
+
+// Variable without member access or index, like TON0 for TON0.Q
+func baseName(variable string) string {
+	if i := strings.IndexAny(variable, ".["); i >= 0 {
+		return variable[:i]
+	}
+	return variable
+}
+
+// Coils of the POU by the variable they drive
+func coilsByVariable(pou *elements.POU) (map[string][]*elements.Element, []string) {
+	coils := make(map[string][]*elements.Element)
+	var variables []string
+	for _, elem := range sortedElements(pou) {
+		if elem.Type != "coil" || elem.TopLabel.Value == "" {
+			continue
+		}
+		variable := elem.TopLabel.Value
+		if _, ok := coils[variable]; !ok {
+			variables = append(variables, variable)
+		}
+		coils[variable] = append(coils[variable], elem)
+	}
+	return coils, variables
+}
+
+func uidList(elems []*elements.Element) ([]string, string) {
+	var uids, described []string
+	for _, elem := range elems {
+		uids = append(uids, elem.UID)
+		described = append(described, "#"+elem.UID)
+	}
+	return uids, strings.Join(described, ", ")
+}
+
+func checkDoubleCoils(program *Program, pou *elements.POU) []Finding {
+	var findings []Finding
+	coils, variables := coilsByVariable(pou)
+	for _, variable := range variables {
+		var driving []*elements.Element
+		for _, coil := range coils[variable] {
+			if coil.Storage.Value == "" {
+				driving = append(driving, coil)
+			}
+		}
+		if len(driving) < 2 {
+			continue
+		}
+		uids, described := uidList(driving)
+		// Located at the second coil, which is the first one to override another
+		uids[0], uids[1] = uids[1], uids[0]
+		findings = append(findings, Finding{
+			Elements: uids,
+			Variable: variable,
+			Message:  fmt.Sprintf("%s is driven by %d coils (%s)", variable, len(driving), described),
+		})
+	}
+	return findings
+}
+
+// Whether something is wired to the pin, either by a connection of the pin
+// itself or by a connection of another element that refers to it
+func isConnected(elem *elements.Element, pin *elements.Pin, pins []*elements.Pin, references map[string]map[string]bool) bool {
+	if len(pin.Connections) > 0 {
+		return true
+	}
+	refs := references[elem.UID]
+	if len(pins) == 1 {
+		return len(refs) > 0
+	}
+	return refs[pin.Label.Value]
+}
+
+func checkUnconnectedPins(program *Program, pou *elements.POU) []Finding {
+	// Pins other elements are wired to, by UID and pin label
+	references := make(map[string]map[string]bool)
+	for _, elem := range pou.Elements {
+		for _, pins := range [][]*elements.Pin{elem.Inputs, elem.Outputs} {
+			for _, pin := range pins {
+				for _, conn := range pin.Connections {
+					if references[conn.TargetRef] == nil {
+						references[conn.TargetRef] = make(map[string]bool)
+					}
+					references[conn.TargetRef][conn.TargetLabel] = true
+				}
+			}
+		}
+	}
+	var findings []Finding
+	check := func(elem *elements.Element, pins []*elements.Pin, side string) {
+		for _, pin := range pins {
+			// Enable inputs of blocks are optional
+			if elem.Type == "block" && strings.EqualFold(pin.Label.Value, "EN") {
+				continue
+			}
+			if isConnected(elem, pin, pins, references) {
+				continue
+			}
+			message := fmt.Sprintf("%s has an unconnected %s", elem.Describe(), side)
+			if elem.Type == "block" {
+				message = fmt.Sprintf("%s has an unconnected pin %s", elem.Describe(), pin.Label.Value)
+			}
+			findings = append(findings, Finding{Elements: []string{elem.UID}, Pin: side + " " + pin.Label.Value, Message: message})
+		}
+	}
+	for _, elem := range sortedElements(pou) {
+		switch elem.Type {
+		case "contact", "coil", "inOutVariable":
+			check(elem, elem.Inputs, "input")
+			check(elem, elem.Outputs, "output")
+		case "block":
+			// Unused outputs of blocks are common, like the elapsed time of a timer
+			check(elem, elem.Inputs, "input")
+		case "inVariable", "continuation":
+			check(elem, elem.Outputs, "output")
+		case "outVariable", "connector":
+			check(elem, elem.Inputs, "input")
+		}
+	}
+	return findings
+}
+
+func checkDanglingReferences(program *Program, pou *elements.POU) []Finding {
+	var findings []Finding
+	for _, elem := range sortedElements(pou) {
+		for _, pins := range [][]*elements.Pin{elem.Inputs, elem.Outputs} {
+			for _, pin := range pins {
+				for _, conn := range pin.Connections {
+					if _, ok := pou.Elements[conn.TargetRef]; ok {
+						continue
+					}
+					findings = append(findings, Finding{
+						Elements: []string{elem.UID},
+						Pin:      pin.Label.Value,
+						Message:  fmt.Sprintf("%s is wired to element #%s, which doesn't exist", elem.Describe(), conn.TargetRef),
+					})
+				}
+			}
+		}
+	}
+	return findings
+}
+
+// Whether the variable gets its value from outside of the ladder diagrams: it's
+// an input of the POU, located or a direct address. Members of structures and
+// function block instances are written by whatever they belong to.
+func isExternallyWritten(program *Program, pou string, variable string) bool {
+	if strings.HasPrefix(variable, "%") || baseName(variable) != variable {
+		return true
+	}
+	declared, ok := program.Lookup(pou, variable)
+	if !ok {
+		return false
+	}
+	return declared.Address != "" || declared.Section == "inputVars" || declared.Section == "inOutVars"
+}
+
+func checkNeverWritten(program *Program, pou *elements.POU) []Finding {
+	written := make(map[string]bool)
+	for _, usages := range program.Usages {
+		for _, usage := range usages {
+			if usage.Access != elements.AccessRead {
+				written[strings.ToLower(usage.Variable)] = true
+			}
+		}
+	}
+	var findings []Finding
+	for _, elem := range sortedElements(pou) {
+		variable := elem.TopLabel.Value
+		if elem.Type != "contact" || variable == "" || written[strings.ToLower(variable)] || isExternallyWritten(program, pou.Name, variable) {
+			continue
+		}
+		findings = append(findings, Finding{
+			Elements: []string{elem.UID},
+			Variable: variable,
+			Message:  fmt.Sprintf("%s reads %s, which is never written", elem.Kind(), variable),
+		})
+	}
+	return findings
+}
+
+func checkSetWithoutReset(program *Program, pou *elements.POU) []Finding {
+	// Reset anywhere, a global variable can be set in one POU and reset in another
+	reset := make(map[string]bool)
+	for i := range program.POUs {
+		coils, _ := coilsByVariable(&program.POUs[i])
+		for variable, elems := range coils {
+			for _, coil := range elems {
+				if coil.Storage.Value == "R" {
+					reset[strings.ToLower(variable)] = true
+				}
+			}
+		}
+	}
+	var findings []Finding
+	coils, variables := coilsByVariable(pou)
+	for _, variable := range variables {
+		if reset[strings.ToLower(variable)] {
+			continue
+		}
+		var set []*elements.Element
+		for _, coil := range coils[variable] {
+			if coil.Storage.Value == "S" {
+				set = append(set, coil)
+			}
+		}
+		if len(set) == 0 {
+			continue
+		}
+		uids, _ := uidList(set)
+		findings = append(findings, Finding{
+			Elements: uids,
+			Variable: variable,
+			Message:  fmt.Sprintf("%s is set but never reset", variable),
+		})
+	}
+	return findings
+}
+
+func checkOutsideRails(program *Program, pou *elements.POU) []Finding {
+	// Area between the inner edges of the rails, and next to them vertically.
+	// Without right rails, only the left side is bounded. Elements only need to
+	// overlap the rails vertically, blocks often hang below their ends.
+	left, right := math.MaxInt, math.MinInt
+	top, bottom := math.MaxInt, math.MinInt
+	for _, elem := range pou.Elements {
+		switch elem.Type {
+		case "leftPowerRail":
+			left = min(left, elem.Position.X+elem.Width)
+		case "rightPowerRail":
+			right = max(right, elem.Position.X)
+		default:
+			continue
+		}
+		top = min(top, elem.Position.Y)
+		bottom = max(bottom, elem.Position.Y+elem.Height)
+	}
+	if left == math.MaxInt {
+		return nil
+	}
+	if right == math.MinInt {
+		right = math.MaxInt
+	}
+	var findings []Finding
+	for _, elem := range sortedElements(pou) {
+		if elem.Type == "leftPowerRail" || elem.Type == "rightPowerRail" {
+			continue
+		}
+		if elem.Position.X >= left && elem.Position.X+elem.Width <= right && elem.Position.Y+elem.Height > top && elem.Position.Y < bottom {
+			continue
+		}
+		findings = append(findings, Finding{
+			Elements: []string{elem.UID},
+			Message:  fmt.Sprintf("%s lies outside of the power rails", elem.Describe()),
+		})
+	}
+	return findings
+}
+
+func checkUnusedVariables(program *Program, pou *elements.POU) []Finding {
+	used := make(map[string]bool)
+	for _, usage := range program.Usages[pou.Name] {
+		used[strings.ToLower(baseName(usage.Variable))] = true
+	}
+	// Instances of function blocks are used by their blocks
+	for _, elem := range pou.Elements {
+		if elem.Type == "block" && elem.TopLabel.Value != "" {
+			used[strings.ToLower(elem.TopLabel.Value)] = true
+		}
+	}
+	var findings []Finding
+	for _, declared := range program.Variables[pou.Name] {
+		if declared.Section != "localVars" && declared.Section != "tempVars" {
+			continue
+		}
+		if used[strings.ToLower(declared.Name)] {
+			continue
+		}
+		findings = append(findings, Finding{
+			Variable: declared.Name,
+			Message:  fmt.Sprintf("Local variable %s is never used", declared.Name),
+		})
+	}
+	return findings
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	elements "openplc-render/elements"
+)
+
+// One assignment in the JSON output
+type logicEntry struct {
+	POU        string `json:"pou"`
+	Rung       int    `json:"rung,omitempty"`
+	Element    string `json:"element"`
+	Kind       string `json:"kind"`
+	Variable   string `json:"variable"`
+	Expression string `json:"expression"`
+	Statement  string `json:"statement"`
+	Change     string `json:"change,omitempty"`
+	Old        string `json:"old,omitempty"`
+}
+
+// Prints the boolean expression driving every coil and output variable, or
+// how they changed between two versions
+func runLogic(args []string) error {
+	flags := flag.NewFlagSet("logic", flag.ExitOnError)
+	filePath := flags.String("file", "", "Path to file inside the git repo")
+	var pouNames refList
+	flags.Var(&pouNames, "pou", "Which POU to extract the logic of (repeatable), all POUs with a ladder diagram otherwise")
+	var refs refList
+	flags.Var(&refs, "ref", "Version of the file, or two versions to compare the expressions of (repeatable)")
+	format := flags.String("format", "text", "Output format: text or json")
+	changesOnly := flags.Bool("changes-only", false, "When comparing two versions, leave out unchanged assignments")
+	output := flags.String("output", "", "File to write the logic to, standard output otherwise")
+	flags.Parse(args)
+
+	if *filePath == "" {
+		return fmt.Errorf("error: file path not provided")
+	}
+	if len(refs) == 0 {
+		refs = append(refs, "HEAD")
+	}
+	if len(refs) > 2 {
+		return fmt.Errorf("error: at most two refs can be compared")
+	}
+	if *format != "text" && *format != "json" {
+		return fmt.Errorf("error: unsupported output format %s", *format)
+	}
+	var logic []elements.Logic
+	for i, ref := range refs {
+		pous, err := loadPOUs(*filePath, ref, pouNames)
+		if err != nil {
+			return err
+		}
+		var ref_logic []elements.Logic
+		for j := range pous {
+			ref_logic = append(ref_logic, pous[j].Logic()...)
+		}
+		elements.SortLogic(ref_logic)
+		if i == 0 {
+			logic = ref_logic
+		} else {
+			logic = elements.DiffLogic(logic, ref_logic)
+		}
+	}
+	if *changesOnly {
+		var changed []elements.Logic
+		for _, assignment := range logic {
+			if assignment.Diff != elements.DiffUnchanged {
+				changed = append(changed, assignment)
+			}
+		}
+		logic = changed
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	if *format == "json" {
+		return writeLogicJSON(w, logic)
+	}
+	return writeLogicText(w, logic, len(refs) == 2)
+}
+
+// Where the assignment is, like "main, rung 2, element #13"
+func logicLocation(assignment elements.Logic) string {
+	if assignment.Rung == 0 {
+		return fmt.Sprintf("%s, element #%s", assignment.POU, assignment.Element)
+	}
+	return fmt.Sprintf("%s, rung %d, element #%s", assignment.POU, assignment.Rung, assignment.Element)
+}
+
+// One statement per line. When comparing, lines are prefixed like in a unified
+// diff, modified statements take a line for each version.
+func writeLogicText(w io.Writer, logic []elements.Logic, diff bool) error {
+	write := func(prefix string, assignment elements.Logic, statement string) error {
+		if diff {
+			prefix += " "
+		}
+		_, err := fmt.Fprintf(w, "%s%s: %s\n", prefix, logicLocation(assignment), statement)
+		return err
+	}
+	for _, assignment := range logic {
+		var err error
+		switch assignment.Diff {
+		case elements.DiffAdded:
+			err = write("+", assignment, assignment.Statement)
+		case elements.DiffDeleted:
+			err = write("-", assignment, assignment.Statement)
+		case elements.DiffModified:
+			if err = write("-", assignment, assignment.Old); err == nil {
+				err = write("+", assignment, assignment.Statement)
+			}
+		default:
+			prefix := ""
+			if diff {
+				prefix = " "
+			}
+			err = write(prefix, assignment, assignment.Statement)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeLogicJSON(w io.Writer, logic []elements.Logic) error {
+	entries := make([]logicEntry, 0, len(logic))
+	for _, assignment := range logic {
+		change := ""
+		if assignment.Diff != elements.DiffUnchanged {
+			change = assignment.Diff.String()
+		}
+		entries = append(entries, logicEntry{
+			POU:        assignment.POU,
+			Rung:       assignment.Rung,
+			Element:    assignment.Element,
+			Kind:       assignment.Kind,
+			Variable:   assignment.Variable,
+			Expression: assignment.Expression,
+			Statement:  assignment.Statement,
+			Change:     change,
+			Old:        assignment.Old,
+		})
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(entries)
+}
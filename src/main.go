@@ -10,9 +10,13 @@ import (
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"strings"
+	"sync"
 
 	elements "openplc-render/elements"
+	export "openplc-render/export"
+	lint "openplc-render/lint"
 	svg "openplc-render/svg"
 	plcxml "openplc-render/xml"
 )
@@ -29,15 +33,78 @@ func (r *refList) Set(value string) error {
 }
 
 func main() {
+	// Subcommands, the default mode without one renders/diffs a single POU
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "history":
+			if err := runHistory(os.Args[2:]); err != nil {
+				log.Fatal(err)
+			}
+			return
+		case "blame":
+			if err := runBlame(os.Args[2:]); err != nil {
+				log.Fatal(err)
+			}
+			return
+		case "xref":
+			if err := runXref(os.Args[2:]); err != nil {
+				log.Fatal(err)
+			}
+			return
+		case "logic":
+			if err := runLogic(os.Args[2:]); err != nil {
+				log.Fatal(err)
+			}
+			return
+		case "lint":
+			clean, err := runLint(os.Args[2:])
+			if err != nil {
+				log.Fatal(err)
+			}
+			// Errors fail the build when linting in CI
+			if !clean {
+				os.Exit(1)
+			}
+			return
+		case "merge":
+			clean, err := runMerge(os.Args[2:])
+			if err != nil {
+				log.Fatal(err)
+			}
+			// Git expects a non-zero exit code from a merge driver on conflicts
+			if !clean {
+				os.Exit(1)
+			}
+			return
+		}
+	}
 	// Get flags
 	// File path, required
 	filePath := flag.String("file", "", "Path to file inside the git repo")
 	// Refs for diffing (or one rep for rendering without diff)
 	var refs refList
 	flag.Var(&refs, "ref", "One or two commit SHAs (repeatable, e.g. --ref abc --ref def)")
-	pouName := flag.String("pou", "", "Which POU to render")
+	var pouNames refList
+	flag.Var(&pouNames, "pou", "Which POU to render (repeatable, POUs are rendered in parallel)")
+	allPous := flag.Bool("all-pous", false, "Render every POU with a ladder diagram, in parallel")
 	outputFolder := flag.String("output", "", "Folder for output .svg files, will put them in a system temporary folder otherwise")
-	style := flag.String("style", "dark", "Diagram style, \"light\"/\"dark\", dark by default")
+	style := flag.String("style", "dark", "Diagram style, name of a built-in or loaded theme, dark by default")
+	themeFile := flag.String("theme", "", "JSON, YAML or TOML file with a custom theme, used instead of --style")
+	base := flag.String("base", "", "Merge base for a three-way diff of two refs, computed automatically when both refs are branch names")
+	overlay := flag.Bool("overlay", false, "When diffing, render both versions as a single overlaid diagram")
+	changesOnly := flag.Bool("changes-only", false, "Only render rungs with changes, folding unchanged ones away")
+	context := flag.Int("context", 1, "Number of unchanged rungs to keep around changed ones with --changes-only")
+	titleBlock := flag.Bool("title-block", false, "Add a title block with project, POU, version and author, and a legend of the diff styles")
+	virtualWires := flag.Bool("virtual-wires", false, "Draw dashed lines from connectors to their continuations")
+	format := flag.String("format", "svg", "Output format: svg, png or pdf (a single document with all diagrams)")
+	paperName := flag.String("paper", "a4", "Paper size for pdf output, a4 or a3, large diagrams are tiled over several pages")
+	compactBlocks := flag.Bool("compact-blocks", false, "Show variables and literals wired only to a block pin as arguments next to the pin, instead of as boxes")
+	grid := flag.Bool("grid", false, "Draw the OpenPLC editor grid behind the diagram, with coordinate rulers")
+	debugIDs := flag.Bool("debug-ids", false, "Print the local ID of every element next to it")
+	navigation := flag.Bool("navigation", false, "Add a minimap and n/p keys for jumping between changed rungs to the .svg files")
+	lintChanges := flag.Bool("lint", false, "When diffing, lint both refs and report, and highlight, only the findings the second ref introduces. Exits with status 1 on new errors")
+	lintConfig := flag.String("lint-config", "", "JSON, YAML or TOML file with the severities of lint rules, for --lint")
+	fitLabels := flag.String("fit-labels", "truncate", "How labels too wide for their space are fitted: truncate (with the full label as tooltip), shrink or none")
 
 	flag.Parse()
 
@@ -46,28 +113,111 @@ func main() {
 	if *filePath == "" {
 		log.Fatal("error: file path not provided")
 	}
-	if *pouName == "" {
+	if len(pouNames) == 0 && !*allPous {
 		log.Fatal("error: pou name not provided")
 	}
 
+	if *format != "svg" && *format != "png" && *format != "pdf" {
+		log.Fatalf("error: unsupported output format %s", *format)
+	}
+	paper, ok := export.Papers[strings.ToLower(*paperName)]
+	if !ok {
+		log.Fatalf("error: unsupported paper size %s", *paperName)
+	}
+	labelFit, ok := svg.LabelFits[strings.ToLower(*fitLabels)]
+	if !ok {
+		log.Fatalf("error: unsupported label fitting %s", *fitLabels)
+	}
+	if *context < 0 {
+		log.Fatalf("error: --context can't be negative, got %d", *context)
+	}
+	if err := loadThemeFile(*themeFile, style); err != nil {
+		log.Fatal(err)
+	}
+	if err := checkStyle(*style); err != nil {
+		log.Fatal(err)
+	}
 	// Ensure output directory exists or gets created
-	if *outputFolder == "" {
-		tmp, err := os.MkdirTemp("", "lad_differ-*")
-		if err != nil {
-			log.Fatalf("failed to create temp directory: %s", err)
-		}
-		*outputFolder = tmp
-	} else {
-		if err := os.MkdirAll(*outputFolder, 0755); err != nil {
-			log.Fatalf("failed to create output directory: %s", err)
-		}
+	folder, err := prepareOutputFolder(*outputFolder)
+	if err != nil {
+		log.Fatal(err)
 	}
+	*outputFolder = folder
 	log.Printf("output folder path: %s", *outputFolder)
 
-	err := renderFiles(*filePath, *pouName, *outputFolder, *style, []string(refs)...)
+	renderer := svg.NewRenderer(*style)
+	renderer.ChangesOnly = *changesOnly
+	renderer.Context = *context
+	renderer.VirtualWires = *virtualWires
+	renderer.LabelFit = labelFit
+	renderer.CompactBlocks = *compactBlocks
+	renderer.Grid = *grid
+	renderer.DebugIDs = *debugIDs
+	renderer.Navigation = *navigation
+	options := renderOptions{
+		Renderer:   renderer,
+		Base:       *base,
+		Overlay:    *overlay,
+		TitleBlock: *titleBlock,
+		Format:     *format,
+		Paper:      paper,
+	}
+	if *lintChanges {
+		options.Lint, err = lintSeverities(*lintConfig, nil)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	// Listed by renderFiles, once it knows every version to render
+	if *allPous {
+		pouNames = nil
+	}
+	clean, err := renderFiles(*filePath, pouNames, *outputFolder, options, []string(refs)...)
 	if err != nil {
 		log.Fatal(err)
 	}
+	// New lint errors fail the build when diffing in CI
+	if !clean {
+		os.Exit(1)
+	}
+}
+
+// Loads a custom theme if a file is given and makes it the style to render with
+func loadThemeFile(path string, style *string) error {
+	if path == "" {
+		return nil
+	}
+	theme, err := svg.LoadTheme(path)
+	if err != nil {
+		return err
+	}
+	log.Printf("loaded theme %s from %s", theme.Name, path)
+	*style = theme.Name
+	return nil
+}
+
+// Rendering falls back to dark with an unknown style, which would hand a
+// misspelled colorblind palette to someone who can't read the dark one
+func checkStyle(style string) error {
+	if !slices.Contains(svg.ThemeNames(), style) {
+		return fmt.Errorf("error: unsupported style %s, available: %s", style, strings.Join(svg.ThemeNames(), ", "))
+	}
+	return nil
+}
+
+// Creates the output folder, or a temporary one if no path is given
+func prepareOutputFolder(path string) (string, error) {
+	if path == "" {
+		tmp, err := os.MkdirTemp("", "lad_differ-*")
+		if err != nil {
+			return "", fmt.Errorf("failed to create temp directory: %w", err)
+		}
+		return tmp, nil
+	}
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return "", fmt.Errorf("failed to create output directory: %w", err)
+	}
+	return path, nil
 }
 
 func getRepoRoot(filePath string) (string, error) {
@@ -88,6 +238,10 @@ func getRepoRoot(filePath string) (string, error) {
 }
 
 func getFileContentsFromGit(filePath, ref string) ([]byte, error) {
+	filePath, err := filepath.Abs(filePath)
+	if err != nil {
+		return nil, err
+	}
 	repoPath, err := getRepoRoot(filePath)
 	log.Printf("repo path: %s, error: %s", repoPath, err)
 	if err != nil {
@@ -113,20 +267,54 @@ func getFileContentsFromGit(filePath, ref string) ([]byte, error) {
 	return stdout.Bytes(), nil
 }
 
-func writeOutputFiles(outputFolder string, files []svg.SVGFile) error {
+// Writes the files as <prefix>_0.svg, <prefix>_1.svg and so on, or as .png
+func writeOutputFiles(outputFolder, prefix, format string, files []svg.SVGFile) error {
 	for i, file := range files {
-		path := filepath.Join(outputFolder, fmt.Sprintf("output_%d.svg", i))
+		path := filepath.Join(outputFolder, fmt.Sprintf("%s_%d.%s", prefix, i, format))
 		f, err := os.Create(path)
 		if err != nil {
 			return err
 		}
-		svgContent, _ := xml.MarshalIndent(file, " ", "  ")
-		f.Write(svgContent)
+		if format == "png" {
+			err = export.PNG(f, file, pngScale)
+		} else {
+			svgContent, _ := xml.MarshalIndent(file, " ", "  ")
+			_, err = f.Write(svgContent)
+		}
 		f.Close()
+		if err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
+// Pixels per diagram unit in png output, diagrams are too small to read at their natural size
+const pngScale = 2
+
+func writePDF(path string, diagrams []export.Diagram, paper export.Paper) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := export.PDF(f, diagrams, paper); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// Which version each of the rendered files of a POU shows, in the order they're rendered
+func versionLabels(refs []string, base string, overlay bool) []string {
+	switch {
+	case base != "":
+		return []string{base + " (base)", refs[0] + " (ours)", refs[1] + " (theirs)"}
+	case overlay && len(refs) == 2:
+		return []string{refs[0] + " → " + refs[1]}
+	}
+	return refs
+}
+
 func openOutputFolder(path string) error {
 	var cmd *exec.Cmd
 
@@ -144,58 +332,307 @@ func openOutputFolder(path string) error {
 	return cmd.Start()
 }
 
-func renderFiles(filePath, pouName, outputFolder, style string, refs ...string) error {
-	var outFiles []svg.SVGFile // Output .svg files, either one or two
+// Options of the default rendering/diffing mode
+type renderOptions struct {
+	Renderer   *svg.Renderer // Shared by all POUs rendered in parallel
+	Base       string        // Merge base for a three-way diff
+	Overlay    bool          // Both versions in one diagram instead of two
+	TitleBlock bool          // Describe the rendered version below the diagram
+	Format     string        // svg, png or pdf
+	Paper      export.Paper  // Page size for pdf
+
+	Lint     map[string]lint.Severity  // Severities of the lint rules, no linting if nil
+	Findings map[string][]lint.Finding // New lint findings by POU, highlighted in the version of the second ref
+}
+
+// Renders a diffed POU according to the options
+func (o renderOptions) render(pou elements.POU, annotations svg.Annotations) svg.SVGFile {
+	return o.Renderer.Render(pou, annotations)
+}
+
+// Adds the title block of the POU version at the ref to the annotations, if enabled
+func (o renderOptions) withHeader(annotations svg.Annotations, filePath, ref string, pou elements.POU) (svg.Annotations, error) {
+	if !o.TitleBlock {
+		return annotations, nil
+	}
+	header, err := loadHeader(filePath, ref, pou)
+	if err != nil {
+		return annotations, err
+	}
+	annotations.Header = &header
+	return annotations, nil
+}
+
+// Flags connectors and continuations that lost their partner between the versions,
+// without touching the maps of the given annotations
+func withDangling(annotations svg.Annotations, old_pou, new_pou *elements.POU, color string) svg.Annotations {
+	dangling := elements.BecameDangling(old_pou, new_pou)
+	if len(dangling) == 0 {
+		return annotations
+	}
+	flagged := make(map[string]svg.Annotation)
+	for uid, annotation := range annotations.Elements {
+		flagged[uid] = annotation
+	}
+	for _, uid := range dangling {
+		annotation := flagged[uid]
+		if annotation.Title != "" {
+			annotation.Title += "\n"
+		}
+		annotation.Title += "Dangling because of this change"
+		if annotation.Highlight == "" {
+			annotation.Highlight = color
+		}
+		flagged[uid] = annotation
+		log.Printf("%s: %s %q is dangling because of the change", new_pou.Name, new_pou.Elements[uid].Type, new_pou.Elements[uid].ElementText.Value)
+	}
+	annotations.Elements = flagged
+	return annotations
+}
+
+// Renders every POU on its own goroutine, each POU gets its own set of output files.
+// Without POU names, every POU with a ladder diagram in any of the rendered
+// versions is rendered. Returns whether the second ref introduces no lint
+// errors, when linting.
+func renderFiles(filePath string, pouNames []string, outputFolder string, options renderOptions, refs ...string) (bool, error) {
 	// If no refs provided - render the file at HEAD
 	if len(refs) == 0 {
 		refs = append(refs, "HEAD")
 	}
-	// Parse the first file regardless of whether the second one is provided
-	contents1, err := getFileContentsFromGit(filePath, refs[0])
+	base, err := resolveMergeBase(filePath, options.Base, refs)
 	if err != nil {
-		log.Fatalf("error fetching file contents via git: %s", err)
+		return false, err
+	}
+	if len(pouNames) == 0 {
+		versions := refs
+		if base != "" {
+			versions = append([]string{base}, refs...)
+		}
+		pouNames, err = listLDPous(filePath, versions...)
+		if err != nil {
+			return false, err
+		}
+	}
+	clean := true
+	if options.Lint != nil {
+		if len(refs) != 2 {
+			return false, fmt.Errorf("error: linting a diff needs exactly two refs, got %d", len(refs))
+		}
+		// The second ref is compared to what it's diffed against
+		old_ref := refs[0]
+		if base != "" {
+			old_ref = base
+		}
+		options.Findings, err = lintDiff(filePath, pouNames, old_ref, refs[1], options.Lint)
+		if err != nil {
+			return false, err
+		}
+		for _, findings := range options.Findings {
+			clean = clean && !hasErrors(findings)
+		}
+	}
+	outFiles := make([][]svg.SVGFile, len(pouNames)) // Either one, two or three with a merge base, per POU
+	errs := make([]error, len(pouNames))
+	var wg sync.WaitGroup
+	for i, pouName := range pouNames {
+		wg.Add(1)
+		go func(i int, pouName string) {
+			defer wg.Done()
+			if base != "" {
+				outFiles[i], errs[i] = renderThreeWay(filePath, pouName, options, base, refs[0], refs[1])
+			} else {
+				outFiles[i], errs[i] = renderTwoWay(filePath, pouName, options, refs)
+			}
+		}(i, pouName)
+	}
+	wg.Wait()
+	var diagrams []export.Diagram // All POUs go into a single pdf
+	for i, pouName := range pouNames {
+		if errs[i] != nil {
+			return false, errs[i]
+		}
+		if options.Format == "pdf" {
+			for j, label := range versionLabels(refs, base, options.Overlay) {
+				diagrams = append(diagrams, export.Diagram{Name: pouName + " @ " + label, File: outFiles[i][j]})
+			}
+			continue
+		}
+		// A single POU keeps the plain output_N names
+		prefix := "output"
+		if len(pouNames) > 1 {
+			prefix = "output_" + pouName
+		}
+		err = writeOutputFiles(outputFolder, prefix, options.Format, outFiles[i])
+		if err != nil {
+			return false, err
+		}
+	}
+	if options.Format == "pdf" {
+		if err := writePDF(filepath.Join(outputFolder, "output.pdf"), diagrams, options.Paper); err != nil {
+			return false, err
+		}
 	}
-	var parsedPou1 elements.POU
-	var project1 plcxml.Project
-	err = xml.Unmarshal(contents1, &project1)
+	err = openOutputFolder(outputFolder)
 	if err != nil {
-		log.Fatalf("Error parsing XML: %v\n", err)
+		return false, err
 	}
-	pou, err := project1.GetPouByName(pouName)
+	return clean, nil
+}
+
+// Renders a single version, or two versions with the diff between them. A POU
+// missing from one of two versions is diffed as an empty one.
+func renderTwoWay(filePath, pouName string, options renderOptions, refs []string) ([]svg.SVGFile, error) {
+	var outFiles []svg.SVGFile
+	// Parse the first file regardless of whether the second one is provided
+	parsedPou1, found1, err := loadPOUAtRef(filePath, pouName, refs[0])
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
-	parsedPou1.Parse(pou)
-	// If the second file is provided - parse it too and get the diff
-	if len(refs) == 2 {
-		contents2, err := getFileContentsFromGit(filePath, refs[1])
-		if err != nil {
-			log.Fatalf("error fetching file contents via git: %s", err)
+	if len(refs) == 1 {
+		if !found1 {
+			return nil, fmt.Errorf("no POU with name %s available at %s", pouName, refs[0])
 		}
-		var parsedPou2 elements.POU
-		var project2 plcxml.Project
-		err = xml.Unmarshal(contents2, &project2)
+		annotations, err := options.withHeader(svg.Annotations{}, filePath, refs[0], parsedPou1)
 		if err != nil {
-			log.Fatalf("Error parsing XML: %v\n", err)
+			return nil, err
 		}
-		pou, err := project2.GetPouByName(pouName)
+		return append(outFiles, options.Renderer.RenderPOU(parsedPou1, annotations)), nil
+	}
+	// The second file is provided - parse it too and get the diff
+	parsedPou2, found2, err := loadPOUAtRef(filePath, pouName, refs[1])
+	if err != nil {
+		return nil, err
+	}
+	if !found1 && !found2 {
+		return nil, fmt.Errorf("no POU with name %s available at %s or %s", pouName, refs[0], refs[1])
+	}
+	if !found1 {
+		log.Printf("%s: not available at %s, diffing against an empty POU", pouName, refs[0])
+	}
+	if !found2 {
+		log.Printf("%s: not available at %s, diffing against an empty POU", pouName, refs[1])
+	}
+	parsedPou1.CalculateDiff(&parsedPou2)
+	for _, change := range parsedPou1.RungChanges(&parsedPou2) {
+		log.Printf("%s: %s", pouName, change)
+	}
+	annotations1, err := options.withHeader(svg.Annotations{}, filePath, refs[0], parsedPou1)
+	if err != nil {
+		return nil, err
+	}
+	annotations2, err := options.withHeader(svg.Annotations{}, filePath, refs[1], parsedPou2)
+	if err != nil {
+		return nil, err
+	}
+	annotations2 = withDangling(annotations2, &parsedPou1, &parsedPou2, options.Renderer.Theme.Deleted)
+	annotations2 = options.withFindings(annotations2, pouName)
+	if options.Overlay {
+		overlay, descriptions := elements.Overlay(&parsedPou1, &parsedPou2)
+		annotations := svg.Annotations{Elements: make(map[string]svg.Annotation)}
+		for uid, description := range descriptions {
+			annotations.Elements[uid] = svg.Annotation{Title: description}
+		}
+		annotations = withDangling(annotations, &parsedPou1, &parsedPou2, options.Renderer.Theme.Deleted)
+		annotations = options.withFindings(annotations, pouName)
+		// Both versions are in the diagram, so is where they come from
+		if options.TitleBlock {
+			header := *annotations2.Header
+			header.Ref = refs[0] + " → " + refs[1]
+			header.SHA = annotations1.Header.SHA + " → " + annotations2.Header.SHA
+			annotations.Header = &header
+		}
+		return append(outFiles, options.render(overlay, annotations)), nil
+	}
+	outFiles = append(outFiles, options.render(parsedPou1, annotations1))
+	outFiles = append(outFiles, options.render(parsedPou2, annotations2))
+	return outFiles, nil
+}
+
+// Names of all POUs with a ladder diagram in any of the versions of the file,
+// in the order they first appear in
+func listLDPous(filePath string, refs ...string) ([]string, error) {
+	var names []string
+	seen := make(map[string]bool)
+	for _, ref := range refs {
+		contents, err := getFileContentsFromGit(filePath, ref)
 		if err != nil {
-			log.Fatal(err)
+			return nil, fmt.Errorf("error fetching file contents via git: %w", err)
+		}
+		var project plcxml.Project
+		if err := xml.Unmarshal(contents, &project); err != nil {
+			return nil, fmt.Errorf("error parsing XML: %w", err)
+		}
+		for _, name := range project.GetLDPouNames() {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
 		}
-		parsedPou2.Parse(pou)
-		parsedPou1.CalculateDiff(&parsedPou2)
-		outFiles = append(outFiles, svg.RenderPOU(parsedPou1, style))
-		outFiles = append(outFiles, svg.RenderPOU(parsedPou2, style))
-	} else {
-		outFiles = append(outFiles, svg.RenderPOU(parsedPou1, style))
 	}
-	err = writeOutputFiles(outputFolder, outFiles)
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no POUs with a ladder diagram available at %s", strings.Join(refs, ", "))
+	}
+	return names, nil
+}
+
+// Describes a version of the POU: the project it belongs to and the commit it comes from
+func loadHeader(filePath, ref string, pou elements.POU) (svg.Header, error) {
+	contents, err := getFileContentsFromGit(filePath, ref)
 	if err != nil {
-		log.Fatal(err)
+		return svg.Header{}, fmt.Errorf("error fetching file contents via git: %w", err)
 	}
-	err = openOutputFolder(outputFolder)
+	var project plcxml.Project
+	if err := xml.Unmarshal(contents, &project); err != nil {
+		return svg.Header{}, fmt.Errorf("error parsing XML: %w", err)
+	}
+	absPath, err := filepath.Abs(filePath)
 	if err != nil {
-		log.Fatal(err)
+		return svg.Header{}, err
 	}
-	return nil
+	repoPath, err := getRepoRoot(absPath)
+	if err != nil {
+		return svg.Header{}, err
+	}
+	commit, err := getCommitInfo(repoPath, ref)
+	if err != nil {
+		return svg.Header{}, err
+	}
+	return svg.Header{
+		Project:        project.ContentHeader.Name,
+		POU:            pou.Name,
+		POUType:        pou.Type,
+		Ref:            ref,
+		SHA:            commit.ShortSHA(),
+		Author:         fmt.Sprintf("%s <%s>", commit.Author, commit.Email),
+		Date:           commit.Date,
+		ProductVersion: project.FileHeader.ProductVersion,
+	}, nil
+}
+
+// Fetches a version of the file and parses the POU out of it, an empty POU with
+// found set to false if the version doesn't have it
+func loadPOUAtRef(filePath, pouName, ref string) (elements.POU, bool, error) {
+	contents, err := getFileContentsFromGit(filePath, ref)
+	if err != nil {
+		return elements.POU{}, false, fmt.Errorf("error fetching file contents via git: %w", err)
+	}
+	return parsePOUFromContents(contents, pouName)
+}
+
+// Parses the POU with the given name out of raw project XML. A POU that
+// doesn't exist in the project yields an empty POU and found set to false,
+// so that callers can diff against versions where it was added or removed.
+func parsePOUFromContents(contents []byte, pouName string) (parsed elements.POU, found bool, err error) {
+	var project plcxml.Project
+	if err := xml.Unmarshal(contents, &project); err != nil {
+		return parsed, false, fmt.Errorf("error parsing XML: %w", err)
+	}
+	pou, err := project.GetPouByName(pouName)
+	if err != nil {
+		parsed.Name = pouName
+		parsed.Elements = make(map[string]*elements.Element)
+		return parsed, false, nil
+	}
+	parsed.Parse(pou)
+	return parsed, true, nil
 }
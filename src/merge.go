@@ -0,0 +1,567 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	elements "openplc-render/elements"
+	plcxml "openplc-render/xml"
+)
+
+// Element-wise merging of PLCopen projects, meant to be used as a git merge driver:
+//
+//	[merge "difflad"]
+//		driver = difflad merge %O %A %B
+//
+// LD bodies are merged element by element using localId, and the connections of
+// elements changed on both sides are merged one by one. Everything outside of the
+// LD bodies is merged as plain text. The result is written over %A.
+
+var (
+	localIdPattern      = regexp.MustCompile(`\b(localId|refLocalId)="([^"]*)"`)
+	modificationPattern = regexp.MustCompile(`modificationDateTime="([^"]*)"`)
+	whitespacePattern   = regexp.MustCompile(`\s+`)
+	betweenTagsPattern  = regexp.MustCompile(`>\s+<`)
+)
+
+// Conflict comments are placed right below the element they're about
+const (
+	conflictCommentWidth  = 300
+	conflictCommentHeight = 40
+)
+
+// One version of a POU's LD body
+type ldSide struct {
+	order     []string          // localIds in document order
+	fragments map[string][]byte // Raw elements by localId
+	indent    []byte            // Whitespace before the first element
+	trailing  []byte            // Whitespace after the last element
+	pou       elements.POU
+}
+
+type mergeConflict struct {
+	LocalId  string
+	Position elements.Position
+	Message  string
+}
+
+func runMerge(args []string) (clean bool, err error) {
+	if len(args) != 3 {
+		return false, fmt.Errorf("usage: difflad merge <base> <ours> <theirs>")
+	}
+	var versions [3][]byte
+	for i, path := range args {
+		versions[i], err = os.ReadFile(path)
+		if err != nil {
+			return false, err
+		}
+	}
+	merged, clean, err := mergeProjects(versions[0], versions[1], versions[2])
+	if err != nil {
+		return false, err
+	}
+	if err := os.WriteFile(args[1], merged, 0644); err != nil {
+		return false, err
+	}
+	return clean, nil
+}
+
+func mergeProjects(base, ours, theirs []byte) ([]byte, bool, error) {
+	theirs, err := renumberCollisions(base, ours, theirs)
+	if err != nil {
+		return nil, false, err
+	}
+	var bodies [3][]plcxml.LDBody
+	var skeletons [3][]byte
+	for i, data := range [][]byte{base, ours, theirs} {
+		bodies[i], err = plcxml.ScanLDBodies(data)
+		if err != nil {
+			return nil, false, err
+		}
+		skeletons[i] = skeleton(data, bodies[i])
+	}
+	normalizeModificationTime(skeletons[:])
+	merged, clean, err := mergeText(skeletons[0], skeletons[1], skeletons[2])
+	if err != nil {
+		return nil, false, err
+	}
+	if !clean {
+		log.Printf("conflicts outside of LD bodies, left as text conflict markers")
+	}
+	// Fill the merged LD bodies back in
+	pous := make(map[string]bool)
+	for _, side := range bodies {
+		for _, body := range side {
+			pous[body.POU] = true
+		}
+	}
+	for pou := range pous {
+		var sides [3]*ldSide
+		for i, data := range [][]byte{base, ours, theirs} {
+			sides[i], err = loadLDSide(data, bodies[i], pou)
+			if err != nil {
+				return nil, false, err
+			}
+		}
+		if !bytes.Contains(merged, ldMarker(pou)) {
+			// The POU got deleted, make sure that didn't throw away changes from the other side
+			if ldChanged(sides[0], sides[1]) || ldChanged(sides[0], sides[2]) {
+				log.Printf("conflict in %s: deleted on one side, changed on the other", pou)
+				clean = false
+			}
+			continue
+		}
+		content, conflicts := mergeLD(sides[0], sides[1], sides[2])
+		for _, conflict := range conflicts {
+			log.Printf("conflict in %s, element %s: %s", pou, conflict.LocalId, conflict.Message)
+		}
+		clean = clean && len(conflicts) == 0
+		merged = bytes.Replace(merged, ldMarker(pou), content, 1)
+	}
+	return merged, clean, nil
+}
+
+// Marker line standing in for the content of an LD body while merging the rest as text
+func ldMarker(pou string) []byte {
+	return []byte(fmt.Sprintf("\n<!-- difflad-merge:%s -->\n", pou))
+}
+
+// The project file with the content of every LD body replaced with a marker
+func skeleton(data []byte, bodies []plcxml.LDBody) []byte {
+	var out bytes.Buffer
+	last := 0
+	for _, body := range bodies {
+		out.Write(data[last:body.OuterStart])
+		out.WriteString("<LD>")
+		out.Write(ldMarker(body.POU))
+		out.WriteString("</LD>")
+		last = body.OuterEnd
+	}
+	out.Write(data[last:])
+	return out.Bytes()
+}
+
+// The editor bumps the modification time on every save, which would make every merge
+// conflict on it. Both sides get the later of the two timestamps instead.
+func normalizeModificationTime(skeletons [][]byte) {
+	latest := ""
+	for _, data := range skeletons[1:] {
+		if match := modificationPattern.FindSubmatch(data); match != nil && string(match[1]) > latest {
+			latest = string(match[1])
+		}
+	}
+	if latest == "" {
+		return
+	}
+	for i, data := range skeletons {
+		skeletons[i] = modificationPattern.ReplaceAll(data, []byte(`modificationDateTime="`+latest+`"`))
+	}
+}
+
+func loadLDSide(data []byte, bodies []plcxml.LDBody, pouName string) (*ldSide, error) {
+	side := &ldSide{fragments: make(map[string][]byte)}
+	// Git passes an empty base when both sides added the file
+	if len(bytes.TrimSpace(data)) == 0 {
+		side.pou = elements.POU{Name: pouName, Elements: make(map[string]*elements.Element)}
+		return side, nil
+	}
+	pou, _, err := parsePOUFromContents(data, pouName)
+	if err != nil {
+		return nil, err
+	}
+	side.pou = pou
+	for _, body := range bodies {
+		if body.POU != pouName {
+			continue
+		}
+		if len(body.Fragments) == 0 {
+			side.indent = data[body.Start:body.End]
+			return side, nil
+		}
+		side.indent = data[body.Start:body.Fragments[0].Start]
+		side.trailing = data[body.Fragments[len(body.Fragments)-1].End:body.End]
+		for _, fragment := range body.Fragments {
+			side.order = append(side.order, fragment.LocalId)
+			side.fragments[fragment.LocalId] = data[fragment.Start:fragment.End]
+		}
+	}
+	return side, nil
+}
+
+// Reports whether a side that still has the LD body changed it relative to the base
+func ldChanged(base, side *ldSide) bool {
+	if len(side.order) == 0 {
+		return false
+	}
+	if len(side.order) != len(base.order) {
+		return true
+	}
+	for id, fragment := range side.fragments {
+		if !sameFragment(fragment, base.fragments[id]) {
+			return true
+		}
+	}
+	return false
+}
+
+// Fragments are compared with whitespace normalized, reformatting isn't a change
+func normalize(fragment []byte) string {
+	normalized := betweenTagsPattern.ReplaceAll(bytes.TrimSpace(fragment), []byte("><"))
+	return string(whitespacePattern.ReplaceAll(normalized, []byte(" ")))
+}
+
+func sameFragment(a, b []byte) bool {
+	return normalize(a) == normalize(b)
+}
+
+// Elements added independently on both sides may have gotten the same localId.
+// Those of theirs get renumbered, along with all the references to them.
+func renumberCollisions(base, ours, theirs []byte) ([]byte, error) {
+	var bodies [3][]plcxml.LDBody
+	for i, data := range [][]byte{base, ours, theirs} {
+		var err error
+		bodies[i], err = plcxml.ScanLDBodies(data)
+		if err != nil {
+			return nil, err
+		}
+	}
+	// Rewrite back to front so that offsets of earlier bodies stay valid
+	for b := len(bodies[2]) - 1; b >= 0; b-- {
+		body := bodies[2][b]
+		var sides [3]*ldSide
+		for i, data := range [][]byte{base, ours, theirs} {
+			var err error
+			sides[i], err = loadLDSide(data, bodies[i], body.POU)
+			if err != nil {
+				return nil, err
+			}
+		}
+		baseSide, oursSide, theirsSide := sides[0], sides[1], sides[2]
+		next := maxLocalId(baseSide, oursSide, theirsSide) + 1
+		renumbered := make(map[string]string)
+		for _, id := range theirsSide.order {
+			_, inBase := baseSide.fragments[id]
+			oursFragment, inOurs := oursSide.fragments[id]
+			if !inBase && inOurs && !sameFragment(oursFragment, theirsSide.fragments[id]) {
+				renumbered[id] = strconv.Itoa(next)
+				log.Printf("%s: element %s added on both sides, renumbering theirs to %d", body.POU, id, next)
+				next++
+			}
+		}
+		if len(renumbered) == 0 {
+			continue
+		}
+		content := localIdPattern.ReplaceAllFunc(theirs[body.Start:body.End], func(match []byte) []byte {
+			parts := localIdPattern.FindSubmatch(match)
+			if id, ok := renumbered[string(parts[2])]; ok {
+				return []byte(fmt.Sprintf(`%s="%s"`, parts[1], id))
+			}
+			return match
+		})
+		theirs = splice(theirs, body.Start, body.End, content)
+	}
+	return theirs, nil
+}
+
+func maxLocalId(sides ...*ldSide) int {
+	max := 0
+	for _, side := range sides {
+		for id := range side.fragments {
+			if n, err := strconv.Atoi(id); err == nil && n > max {
+				max = n
+			}
+		}
+	}
+	return max
+}
+
+// Merges one LD body, returns its new content and the conflicts, which are
+// also written into the content as comment elements
+func mergeLD(base, ours, theirs *ldSide) ([]byte, []mergeConflict) {
+	var order []string
+	seen := make(map[string]bool)
+	for _, id := range append(append([]string{}, ours.order...), theirs.order...) {
+		if !seen[id] {
+			seen[id] = true
+			order = append(order, id)
+		}
+	}
+	// Elements deleted on both sides are only in the base, nothing to do for those
+	var merged [][]byte
+	var conflicts []mergeConflict
+	ids := make(map[string]bool)
+	for _, id := range order {
+		fragment, conflict := mergeElement(id, base, ours, theirs)
+		if fragment != nil {
+			merged = append(merged, fragment)
+			ids[id] = true
+		}
+		if conflict != "" {
+			conflicts = append(conflicts, mergeConflict{LocalId: id, Position: conflictPosition(id, ours, theirs), Message: conflict})
+		}
+	}
+	// Merging both sides cleanly can still leave connections to elements that
+	// one side deleted and the other one started using
+	for i, fragment := range merged {
+		pins, err := plcxml.ScanPins(fragment)
+		if err != nil {
+			continue
+		}
+		for _, pin := range pins {
+			for _, conn := range pin.Connections {
+				if !ids[conn.RefLocalId] {
+					id := fragmentLocalId(merged[i])
+					conflicts = append(conflicts, mergeConflict{
+						LocalId:  id,
+						Position: conflictPosition(id, ours, theirs),
+						Message:  fmt.Sprintf("connection to element %s, which doesn't exist anymore", conn.RefLocalId),
+					})
+				}
+			}
+		}
+	}
+	next := maxLocalId(base, ours, theirs) + 1
+	for _, conflict := range conflicts {
+		merged = append(merged, conflictComment(strconv.Itoa(next), conflict))
+		next++
+	}
+	layout := ours
+	if len(ours.order) == 0 {
+		layout = theirs
+	}
+	var content bytes.Buffer
+	content.Write(layout.indent)
+	content.Write(bytes.Join(merged, layout.indent))
+	if len(merged) > 0 {
+		content.Write(layout.trailing)
+	}
+	return content.Bytes(), conflicts
+}
+
+func fragmentLocalId(fragment []byte) string {
+	if match := localIdPattern.FindSubmatch(fragment); match != nil {
+		return string(match[2])
+	}
+	return ""
+}
+
+// Decides what happens to a single element, returns nil if it's deleted.
+// On conflicts, the version of the side that kept or changed the element wins.
+func mergeElement(id string, base, ours, theirs *ldSide) ([]byte, string) {
+	b, o, t := base.fragments[id], ours.fragments[id], theirs.fragments[id]
+	switch {
+	case o == nil && t == nil:
+		return nil, ""
+	case b == nil && t == nil:
+		return o, ""
+	case b == nil && o == nil:
+		return t, ""
+	case b == nil:
+		// Added on both sides, identical since colliding ones got renumbered
+		return o, ""
+	case o == nil:
+		if sameFragment(t, b) {
+			return nil, ""
+		}
+		return t, "deleted in ours, changed in theirs"
+	case t == nil:
+		if sameFragment(o, b) {
+			return nil, ""
+		}
+		return o, "changed in ours, deleted in theirs"
+	}
+	switch {
+	case sameFragment(o, t), sameFragment(t, b):
+		return o, ""
+	case sameFragment(o, b):
+		return t, ""
+	}
+	return mergeChangedElement(id, b, o, t, base, ours, theirs)
+}
+
+// Both sides changed the element differently: the element itself and its
+// connections are merged separately
+func mergeChangedElement(id string, b, o, t []byte, base, ours, theirs *ldSide) ([]byte, string) {
+	var chosen []byte
+	var conflicts []string
+	fb, fo, ft := stripConnections(b), stripConnections(o), stripConnections(t)
+	switch {
+	case fo == ft, ft == fb:
+		chosen = o
+	case fo == fb:
+		chosen = t
+	case ours.pou.Elements[id].FieldsEqual(theirs.pou.Elements[id]):
+		// Only the layout differs (the element got moved or resized differently),
+		// ours wins but the lost layout of theirs is still reported
+		chosen = o
+		conflicts = append(conflicts, "moved or resized on both sides, kept the layout of ours")
+	default:
+		return o, fmt.Sprintf("changed on both sides: ours %s, theirs %s",
+			describeElement(ours.pou.Elements[id]), describeElement(theirs.pou.Elements[id]))
+	}
+	pinsB, errB := plcxml.ScanPins(b)
+	pinsO, errO := plcxml.ScanPins(o)
+	pinsT, errT := plcxml.ScanPins(t)
+	pinsChosen, errC := plcxml.ScanPins(chosen)
+	if errB != nil || errO != nil || errT != nil || errC != nil ||
+		len(pinsB) != len(pinsO) || len(pinsB) != len(pinsT) {
+		return o, "changed on both sides, with different pins"
+	}
+	// Rebuild the connections of every pin, back to front to keep offsets valid
+	result := append([]byte{}, chosen...)
+	for i := len(pinsChosen) - 1; i >= 0; i-- {
+		connections, pinConflicts := mergeConnections(b, o, t, pinsB[i], pinsO[i], pinsT[i])
+		conflicts = append(pinConflicts, conflicts...)
+		pin := pinsChosen[i]
+		if len(pin.Connections) == 0 {
+			if len(connections) == 0 {
+				continue
+			}
+			if pin.Insert < 0 {
+				return o, "changed on both sides, connections can't be merged"
+			}
+			// New connections go right before the closing tag, after its indentation
+			indent := precedingWhitespace(chosen, pin.Insert)
+			var replacement []byte
+			for _, conn := range connections {
+				replacement = append(append(replacement, conn...), indent...)
+			}
+			result = splice(result, pin.Insert, pin.Insert, replacement)
+			continue
+		}
+		first, last := pin.Connections[0], pin.Connections[len(pin.Connections)-1]
+		indent := precedingWhitespace(chosen, first.Start)
+		start := first.Start
+		if len(connections) == 0 {
+			start -= len(indent)
+		}
+		result = splice(result, start, last.End, bytes.Join(connections, indent))
+	}
+	return result, strings.Join(conflicts, "; ")
+}
+
+// Three-way merge of the connections of a single pin, the same way the diff engine
+// matches them. Connections kept on both sides take the changed version of the wire.
+// A connection changed on one side and deleted on the other is a conflict, the
+// changed version is kept like for elements.
+func mergeConnections(b, o, t []byte, pinB, pinO, pinT plcxml.PinFragment) ([][]byte, []string) {
+	key := func(conn plcxml.ConnectionFragment) string {
+		return conn.RefLocalId + "/" + conn.FormalParameter
+	}
+	index := func(data []byte, pin plcxml.PinFragment) map[string][]byte {
+		conns := make(map[string][]byte)
+		for _, conn := range pin.Connections {
+			conns[key(conn)] = data[conn.Start:conn.End]
+		}
+		return conns
+	}
+	inBase, inOurs, inTheirs := index(b, pinB), index(o, pinO), index(t, pinT)
+	var merged [][]byte
+	var conflicts []string
+	for _, conn := range pinO.Connections {
+		k := key(conn)
+		raw, kept := inTheirs[k]
+		_, based := inBase[k]
+		switch {
+		case kept && sameFragment(inOurs[k], inBase[k]):
+			merged = append(merged, raw)
+		case kept, !based:
+			merged = append(merged, inOurs[k])
+		case !sameFragment(inOurs[k], inBase[k]):
+			merged = append(merged, inOurs[k])
+			conflicts = append(conflicts, fmt.Sprintf("connection to element %s changed in ours, deleted in theirs", conn.RefLocalId))
+		}
+	}
+	for _, conn := range pinT.Connections {
+		k := key(conn)
+		_, based := inBase[k]
+		if _, kept := inOurs[k]; kept {
+			continue
+		}
+		switch {
+		case !based:
+			merged = append(merged, inTheirs[k])
+		case !sameFragment(inTheirs[k], inBase[k]):
+			merged = append(merged, inTheirs[k])
+			conflicts = append(conflicts, fmt.Sprintf("connection to element %s deleted in ours, changed in theirs", conn.RefLocalId))
+		}
+	}
+	return merged, conflicts
+}
+
+// The element without its connections, normalized for comparison
+func stripConnections(fragment []byte) string {
+	pins, err := plcxml.ScanPins(fragment)
+	if err != nil {
+		return normalize(fragment)
+	}
+	var ranges [][2]int
+	for _, pin := range pins {
+		for _, conn := range pin.Connections {
+			ranges = append(ranges, [2]int{conn.Start, conn.End})
+		}
+	}
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i][0] < ranges[j][0] })
+	var stripped []byte
+	last := 0
+	for _, r := range ranges {
+		stripped = append(stripped, fragment[last:r[0]]...)
+		last = r[1]
+	}
+	stripped = append(stripped, fragment[last:]...)
+	return normalize(stripped)
+}
+
+// Replaces data[start:end] with the replacement
+func splice(data []byte, start, end int, replacement []byte) []byte {
+	spliced := append([]byte{}, data[:start]...)
+	spliced = append(spliced, replacement...)
+	return append(spliced, data[end:]...)
+}
+
+func precedingWhitespace(data []byte, offset int) []byte {
+	start := offset
+	for start > 0 && strings.ContainsRune(" \t\r\n", rune(data[start-1])) {
+		start--
+	}
+	return data[start:offset]
+}
+
+func describeElement(elem *elements.Element) string {
+	if elem == nil {
+		return "(not an LD element)"
+	}
+	description := elem.Type
+	for _, label := range []string{elem.BlockLabel.Value, elem.TopLabel.Value, elem.ElementText.Value} {
+		if label != "" {
+			description += " " + strconv.Quote(label)
+		}
+	}
+	return description
+}
+
+func conflictPosition(id string, sides ...*ldSide) elements.Position {
+	for _, side := range sides {
+		if elem, ok := side.pou.Elements[id]; ok {
+			return elements.Position{X: elem.Position.X, Y: elem.Position.Y + elem.Height + 10}
+		}
+	}
+	return elements.Position{}
+}
+
+// Conflicts are recorded in the diagram itself, as comments next to the element
+func conflictComment(localId string, conflict mergeConflict) []byte {
+	var escaped bytes.Buffer
+	xml.EscapeText(&escaped, []byte(fmt.Sprintf("difflad merge conflict on element %s: %s", conflict.LocalId, conflict.Message)))
+	return []byte(fmt.Sprintf(
+		`<comment localId="%s" height="%d" width="%d"><position x="%d" y="%d"/><content><xhtml:p xmlns:xhtml="http://www.w3.org/1999/xhtml">%s</xhtml:p></content></comment>`,
+		localId, conflictCommentHeight, conflictCommentWidth, conflict.Position.X, conflict.Position.Y, escaped.String(),
+	))
+}
@@ -0,0 +1,164 @@
+package svg
+
+import (
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	elements "openplc-render/elements"
+)
+
+// Longer description of the parent element for assistive technology
+type Desc struct {
+	XMLName xml.Name `xml:"desc"`
+	Content string   `xml:",chardata"`
+}
+
+type Metadata struct {
+	XMLName xml.Name `xml:"metadata"`
+	Content string   `xml:",chardata"`
+}
+
+// Glyphs marking changed elements, readable without colors
+var change_glyph = map[elements.Diff]string{
+	elements.DiffAdded:    "+",
+	elements.DiffDeleted:  "−",
+	elements.DiffModified: "~",
+}
+
+// How the element changed, empty if it didn't
+func changeState(elem *elements.Element) string {
+	if elem.Diff != elements.DiffUnchanged {
+		return elem.Diff.String()
+	}
+	if elem.HasChanges() {
+		return "wires changed"
+	}
+	return ""
+}
+
+// Accessible name of the element, like "Normally closed contact stop, deleted"
+func elementTitle(elem *elements.Element) string {
+	title := elem.Describe()
+	if state := changeState(elem); state != "" {
+		title += ", " + state
+	}
+	return title
+}
+
+// Where the element is and what changed about it
+func elementDesc(pou elements.POU, elem *elements.Element) *Desc {
+	var sentences []string
+	if rung := pou.RungOf(elem.UID); rung != nil {
+		sentences = append(sentences, fmt.Sprintf("In rung %d.", rung.Number))
+	}
+	if elem.Diff == elements.DiffModified {
+		if changed := elem.ChangedLabels(); len(changed) > 0 {
+			sentences = append(sentences, "Changed "+strings.Join(changed, ", ")+".")
+		}
+	}
+	if len(sentences) == 0 {
+		return nil
+	}
+	return &Desc{Content: strings.Join(sentences, " ")}
+}
+
+// Space the change glyph takes below the element
+const changeGlyphHeight = CELL_SIZE * 2
+
+// Glyph below the bottom left corner of a changed element
+func (r *Renderer) renderChangeGlyph(elem *elements.Element, group *Group) {
+	glyph, ok := change_glyph[elem.Diff]
+	if !ok {
+		return
+	}
+	group.Text = append(group.Text, Text{
+		X:          elem.Position.X - CELL_SIZE/2,
+		Y:          elem.Position.Y + elem.Height + CELL_SIZE,
+		Content:    glyph,
+		TextAnchor: "middle",
+		FontFamily: r.Theme.FontFamily,
+		FontSize:   strconv.Itoa(r.Theme.FontSize + CELL_SIZE/2),
+		FontWeight: "bold",
+		Fill:       r.color(elem.Diff),
+		Class:      r.class("label", elem.Diff.String()),
+	})
+}
+
+// Short summary of the diagram for its description, like
+// "3 rungs. Changes in rungs 1, 3: 1 added, 2 modified elements."
+func summary(pou elements.POU) string {
+	text := fmt.Sprintf("%d rungs.", len(pou.Rungs))
+	if len(pou.Rungs) == 1 {
+		text = "1 rung."
+	}
+	counts := make(map[elements.Diff]int)
+	for _, elem := range pou.Elements {
+		counts[elem.Diff]++
+	}
+	var parts []string
+	for _, diff := range []elements.Diff{elements.DiffAdded, elements.DiffDeleted, elements.DiffModified} {
+		if counts[diff] > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", counts[diff], diff))
+		}
+	}
+	var rungs []string
+	for _, rung := range pou.Rungs {
+		if pou.RungHasChanges(rung) {
+			rungs = append(rungs, strconv.Itoa(rung.Number))
+		}
+	}
+	where := "rungs " + strings.Join(rungs, ", ")
+	if len(rungs) == 1 {
+		where = "rung " + rungs[0]
+	}
+	switch {
+	case len(rungs) > 0 && len(parts) > 0:
+		text += fmt.Sprintf(" Changes in %s: %s elements.", where, strings.Join(parts, ", "))
+	case len(rungs) > 0:
+		text += fmt.Sprintf(" Wires changed in %s.", where)
+	case len(parts) > 0:
+		text += fmt.Sprintf(" Changes outside of rungs: %s elements.", strings.Join(parts, ", "))
+	}
+	return text
+}
+
+// Every changed element with its rung, one per line, for the metadata of the
+// diagram. Nil if nothing changed.
+func changeList(pou elements.POU) *Metadata {
+	type change struct {
+		rung int
+		uid  string
+		line string
+	}
+	var changes []change
+	for uid, elem := range pou.Elements {
+		state := changeState(elem)
+		if state == "" {
+			continue
+		}
+		number := 0
+		where := "Outside of rungs"
+		if rung := pou.RungOf(uid); rung != nil {
+			number = rung.Number
+			where = fmt.Sprintf("Rung %d", number)
+		}
+		changes = append(changes, change{number, uid, fmt.Sprintf("%s: %s, %s", where, elem.Describe(), state)})
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].rung != changes[j].rung {
+			return changes[i].rung < changes[j].rung
+		}
+		return changes[i].uid < changes[j].uid
+	})
+	lines := []string{"Changes:"}
+	for _, change := range changes {
+		lines = append(lines, change.line)
+	}
+	return &Metadata{Content: strings.Join(lines, "\n")}
+}
@@ -0,0 +1,114 @@
+package svg
+
+import (
+	"strconv"
+
+	elements "openplc-render/elements"
+)
+
+// Width of the rulers above and left of the diagram. They're drawn at negative
+// coordinates, so that the diagram keeps the coordinates of the OpenPLC editor.
+const rulerSize = CELL_SIZE * 3
+
+// Cells between the heavier grid lines and numbered ruler ticks
+const majorGridCells = 10
+
+// Font size of ruler numbers and debug labels
+const smallFontSize = 8
+
+// Grid of OpenPLC editor cells behind the diagram, with every tenth line heavier
+func (r *Renderer) renderGrid(width, height int) Group {
+	group := Group{}
+	line := func(x1, y1, x2, y2, cell int) Line {
+		opacity := float32(0.1)
+		if cell%majorGridCells == 0 {
+			opacity = 0.25
+		}
+		return Line{
+			X1:            x1,
+			Y1:            y1,
+			X2:            x2,
+			Y2:            y2,
+			Stroke:        r.color(elements.DiffUnchanged),
+			Class:         r.class("grid", elements.DiffUnchanged.String()),
+			StrokeWidth:   1,
+			StrokeOpacity: opacity,
+		}
+	}
+	for x := 0; x <= width; x += CELL_SIZE {
+		group.Line = append(group.Line, line(x, 0, x, height, x/CELL_SIZE))
+	}
+	for y := 0; y <= height; y += CELL_SIZE {
+		group.Line = append(group.Line, line(0, y, width, y, y/CELL_SIZE))
+	}
+	return group
+}
+
+// Rulers along the top and left edges with a tick for every cell and the
+// coordinates of every tenth
+func (r *Renderer) renderRulers(width, height int) Group {
+	group := Group{}
+	tick := func(position, cell int, vertical bool) {
+		length := CELL_SIZE / 4
+		switch {
+		case cell%majorGridCells == 0:
+			length = CELL_SIZE
+		case cell%(majorGridCells/2) == 0:
+			length = CELL_SIZE / 2
+		}
+		line := Line{
+			X1:          position,
+			Y1:          -length,
+			X2:          position,
+			Y2:          0,
+			Stroke:      r.color(elements.DiffUnchanged),
+			Class:       r.class(elements.DiffUnchanged.String()),
+			StrokeWidth: 1,
+		}
+		if vertical {
+			line.X1, line.Y1, line.X2, line.Y2 = -length, position, 0, position
+		}
+		group.Line = append(group.Line, line)
+		if cell%majorGridCells != 0 {
+			return
+		}
+		text := Text{
+			X:          position,
+			Y:          -CELL_SIZE - CELL_SIZE/4,
+			Content:    strconv.Itoa(position),
+			TextAnchor: "middle",
+			FontFamily: r.Theme.FontFamily,
+			FontSize:   strconv.Itoa(smallFontSize),
+			Fill:       r.color(elements.DiffUnchanged),
+			Class:      r.class("label", elements.DiffUnchanged.String()),
+		}
+		if vertical {
+			text.X, text.Y, text.TextAnchor = -CELL_SIZE-CELL_SIZE/4, position+smallFontSize/2-1, "end"
+		}
+		group.Text = append(group.Text, text)
+	}
+	for x := 0; x <= width; x += CELL_SIZE {
+		tick(x, x/CELL_SIZE, false)
+	}
+	for y := 0; y <= height; y += CELL_SIZE {
+		tick(y, y/CELL_SIZE, true)
+	}
+	return group
+}
+
+// Local ID of the element in small print above its top right corner, for
+// finding it in the PLCopen XML
+func (r *Renderer) renderDebugID(elem *elements.Element, group *Group) {
+	group.Text = append(group.Text, Text{
+		X:           elem.Position.X + elem.Width + CELL_SIZE/4,
+		Y:           elem.Position.Y - CELL_SIZE/4,
+		Content:     "#" + elem.UID,
+		TextAnchor:  "start",
+		FontFamily:  r.Theme.FontFamily,
+		FontSize:    strconv.Itoa(smallFontSize),
+		FontStyle:   "italic",
+		Fill:        r.color(elements.DiffUnchanged),
+		FillOpacity: 0.6,
+		Class:       r.class("label", elements.DiffUnchanged.String()),
+	})
+}
@@ -0,0 +1,121 @@
+package svg
+
+import (
+	"math"
+	"strconv"
+
+	elements "openplc-render/elements"
+)
+
+// How labels too wide for the space they have are made to fit
+type LabelFit int
+
+const (
+	FitTruncate LabelFit = iota // Cut off with an ellipsis, the full label is in a tooltip
+	FitShrink                   // Set in a smaller font, truncated if even the smallest is too wide
+	FitNone                     // Left as they are, overlapping whatever is next to them
+)
+
+var LabelFits = map[string]LabelFit{
+	"truncate": FitTruncate,
+	"shrink":   FitShrink,
+	"none":     FitNone,
+}
+
+// Labels don't shrink below this fraction of their font size
+const minLabelScale = 0.75
+
+// Makes the text fit into the width according to the renderer's label fitting.
+// Fitted texts get their measured width as text length, so that browsers lay
+// them out to the same width even when they fall back to another font.
+func (r *Renderer) fitText(text *Text, width int) {
+	if r.LabelFit == FitNone || width <= 0 || text.Content == "" {
+		return
+	}
+	size := defaultFontSize
+	if s, err := strconv.Atoi(text.FontSize); err == nil {
+		size = s
+	}
+	bold := text.FontWeight == "bold"
+	measured := textWidth(text.Content, size, bold)
+	if measured <= float64(width) {
+		return
+	}
+	if r.LabelFit == FitShrink {
+		min_size := int(math.Ceil(float64(size) * minLabelScale))
+		size = max(int(float64(size)*float64(width)/measured), min_size)
+		text.FontSize = strconv.Itoa(size)
+		measured = textWidth(text.Content, size, bold)
+	}
+	if measured > float64(width) {
+		text.Title = &Title{Content: text.Content}
+		text.Content = truncate(text.Content, float64(width), size, bold)
+		measured = textWidth(text.Content, size, bold)
+	}
+	text.TextLength = strconv.Itoa(int(math.Ceil(measured)))
+	text.LengthAdjust = "spacingAndGlyphs"
+}
+
+func hasTopLabel(elem *elements.Element) bool {
+	return elem.Type == "contact" || elem.Type == "coil" || elem.Type == "block"
+}
+
+// Width available to the label centered above every contact, coil and block,
+// by UID. Labels of neighbors split the space between them, other elements are
+// kept clear of. Zero for labels without neighbors, which have all the space.
+func labelSpace(pou elements.POU) map[string]int {
+	space := make(map[string]int)
+	for uid, elem := range pou.Elements {
+		if !hasTopLabel(elem) {
+			continue
+		}
+		center := elem.Position.X + elem.Width/2
+		row_top, row_bottom := elem.Position.Y-CELL_SIZE*2, elem.Position.Y
+		half := math.MaxInt
+		for other_uid, other := range pou.Elements {
+			if other_uid == uid {
+				continue
+			}
+			top := other.Position.Y
+			if hasTopLabel(other) {
+				top -= CELL_SIZE * 2
+			}
+			if top >= row_bottom || other.Position.Y+other.Height <= row_top {
+				continue
+			}
+			other_center := other.Position.X + other.Width/2
+			var limit int
+			switch {
+			case hasTopLabel(other):
+				limit = (center + other_center) / 2
+			case other_center < center:
+				limit = other.Position.X + other.Width
+			default:
+				limit = other.Position.X
+			}
+			half = min(half, abs(limit-center)-CELL_SIZE/4)
+		}
+		if half != math.MaxInt {
+			space[uid] = max(half*2, elem.Width)
+		}
+	}
+	return space
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// Width available to the label of a block pin, which shares the row with the
+// label of the pin on the opposite side if there is one
+func pinLabelSpace(block *elements.Element, pin *elements.Pin, opposite []*elements.Pin) int {
+	for _, other := range opposite {
+		if other.Position.Y == pin.Position.Y && other.Label.Value != "" {
+			return block.Width/2 - CELL_SIZE/2 - CELL_SIZE/4
+		}
+	}
+	return block.Width - CELL_SIZE
+}
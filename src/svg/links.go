@@ -0,0 +1,100 @@
+package svg
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+
+	elements "openplc-render/elements"
+)
+
+// Hyperlink around rendered geometry, jumps to the element with the ID in Href
+type Anchor struct {
+	XMLName xml.Name `xml:"a"`
+	Href    string   `xml:"href,attr"`
+	Group   Group
+}
+
+// ID of the group an element is rendered into. UIDs of derived elements
+// contain characters IDs can't have, like rail pieces of folded POUs.
+func (r *Renderer) elementID(uid string) string {
+	return r.IDPrefix + "elem-" + strings.NewReplacer("'", "-ghost", "@", "-rung-").Replace(uid)
+}
+
+// Describes where the connector or continuation continues, or that it doesn't
+func linkTitle(pou elements.POU, elem *elements.Element, link *elements.Link) string {
+	partners := link.Partners(elem.UID)
+	if len(partners) == 0 {
+		if elem.Type == "connector" {
+			return fmt.Sprintf("Dangling connector %q: no continuation with this name", link.Name)
+		}
+		return fmt.Sprintf("Dangling continuation %q: no connector with this name", link.Name)
+	}
+	var rungs []string
+	seen := make(map[int]bool)
+	for _, uid := range partners {
+		if rung := pou.RungOf(uid); rung != nil && !seen[rung.Number] {
+			seen[rung.Number] = true
+			rungs = append(rungs, strconv.Itoa(rung.Number))
+		}
+	}
+	where := ""
+	if len(rungs) > 0 {
+		where = " in rung " + strings.Join(rungs, ", ")
+	}
+	if elem.Type == "connector" {
+		return fmt.Sprintf("Connector %q, continued%s", link.Name, where)
+	}
+	return fmt.Sprintf("Continuation %q, connected%s", link.Name, where)
+}
+
+// Warning badge on the top right corner of a dangling connector or continuation
+func (r *Renderer) renderDanglingMarker(elem *elements.Element, group *Group) {
+	x, y := elem.Position.X+elem.Width, elem.Position.Y
+	// A rect rather than a polygon, groups draw their rects before the texts
+	size := CELL_SIZE/2 + CELL_SIZE/4
+	group.Rect = append(group.Rect, Rect{
+		Width:  size * 2,
+		Height: size * 2,
+		X:      x - size,
+		Y:      y - size,
+		Fill:   r.color(elements.DiffDeleted),
+		Class:  r.class("marker", elements.DiffDeleted.String()),
+	})
+	group.Text = append(group.Text, Text{
+		X:          x,
+		Y:          y + CELL_SIZE/3,
+		Content:    "!",
+		TextAnchor: "middle",
+		FontFamily: r.Theme.FontFamily,
+		FontSize:   strconv.Itoa(CELL_SIZE),
+		FontWeight: "bold",
+		Fill:       r.Theme.Background,
+		Class:      r.class("inverse"),
+	})
+}
+
+// Dashed lines from every connector to its continuations, standing in for the wire they replace
+func (r *Renderer) renderVirtualWires(pou elements.POU, links map[string]*elements.Link) Group {
+	group := Group{}
+	for _, link := range links {
+		for _, from := range link.Connectors {
+			for _, to := range link.Continuations {
+				a, b := pou.Elements[from], pou.Elements[to]
+				group.Polyline = append(group.Polyline, Polyline{
+					Points: fmt.Sprintf("%d,%d %d,%d",
+						a.Position.X+a.Width, a.Position.Y+a.Height/2,
+						b.Position.X, b.Position.Y+b.Height/2),
+					Stroke:          r.color(elements.DiffUnchanged),
+					Class:           r.class(elements.DiffUnchanged.String()),
+					StrokeWidth:     1,
+					StrokeDasharray: "6 3",
+					Fill:            "transparent",
+					Title:           &Title{Content: fmt.Sprintf("Virtual wire %q", link.Name)},
+				})
+			}
+		}
+	}
+	return group
+}
@@ -0,0 +1,65 @@
+package svg
+
+// Advance widths of the printable ASCII characters (space to tilde) in 1/1000 em,
+// from the Helvetica metrics, which Arial shares. Good enough for the sans-serif
+// fonts themes use, and exact for the PDF export.
+var helvetica_widths = [95]int{
+	278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+	1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+	333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+	556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584,
+}
+
+var helvetica_bold_widths = [95]int{
+	278, 333, 474, 556, 556, 889, 722, 238, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 333, 333, 584, 584, 584, 611,
+	975, 722, 722, 722, 722, 667, 611, 778, 722, 278, 556, 722, 611, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 333, 278, 333, 584, 556,
+	333, 556, 611, 556, 611, 556, 333, 611, 611, 278, 278, 556, 278, 889, 611, 611,
+	611, 611, 389, 556, 333, 611, 556, 778, 556, 556, 500, 389, 280, 389, 584,
+}
+
+// Width of characters outside of the tables, about that of a digit
+const defaultCharWidth = 556
+
+const ellipsis = "…"
+
+// Font size browsers use when a text doesn't set one
+const defaultFontSize = 16
+
+func charWidth(c rune, bold bool) int {
+	switch {
+	case c == '…':
+		return 1000
+	case c < ' ' || c > '~':
+		return defaultCharWidth
+	case bold:
+		return helvetica_bold_widths[c-' ']
+	}
+	return helvetica_widths[c-' ']
+}
+
+// Width of the text in SVG units when set in the given font size
+func textWidth(text string, size int, bold bool) float64 {
+	total := 0
+	for _, c := range text {
+		total += charWidth(c, bold)
+	}
+	return float64(total*size) / 1000
+}
+
+// Longest prefix of the text that fits into the width together with an ellipsis,
+// just the ellipsis if not even a single character fits
+func truncate(text string, width float64, size int, bold bool) string {
+	available := width - textWidth(ellipsis, size, bold)
+	used := 0.0
+	for i, c := range text {
+		used += textWidth(string(c), size, bold)
+		if used > available {
+			return text[:i] + ellipsis
+		}
+	}
+	return text
+}
@@ -0,0 +1,199 @@
+package svg
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strings"
+
+	elements "openplc-render/elements"
+)
+
+// Script embedded into the SVG
+type Script struct {
+	XMLName xml.Name `xml:"script"`
+	Content string   `xml:",cdata"`
+}
+
+// Thumbnail of the diagram with its changed rungs marked, in diagram coordinates.
+// Hidden until the navigation script scales it into a corner of the view, and
+// left out of PNG and PDF output.
+type Minimap struct {
+	XMLName xml.Name `xml:"g"`
+	Class   string   `xml:"class,attr"`
+	Display string   `xml:"display,attr,omitempty"`
+	Rect    []Rect   `xml:"rect"`
+}
+
+// Width of the minimap on screen, in pixels
+const minimapWidth = 160
+
+// How the rung changed as a whole: added or deleted if all of its changed
+// elements were, modified otherwise
+func rungDiff(pou elements.POU, rung *elements.Rung) elements.Diff {
+	diff := elements.DiffUnchanged
+	for _, uid := range rung.Elements {
+		elem := pou.Elements[uid]
+		switch {
+		case !elem.HasChanges():
+			continue
+		case elem.Diff == elements.DiffAdded || elem.Diff == elements.DiffDeleted:
+			if diff != elements.DiffUnchanged && diff != elem.Diff {
+				return elements.DiffModified
+			}
+			diff = elem.Diff
+		default:
+			return elements.DiffModified
+		}
+	}
+	return diff
+}
+
+// Joins the class the navigation script looks for with the theme classes
+func navigationClass(name, theme string) string {
+	return strings.TrimSpace(name + " " + theme)
+}
+
+// Minimap of the diagram within the view box. Every element is a box in its diff
+// color, changed rungs get a band with a marker on the left edge, which also
+// are the stops of the change navigation.
+func (r *Renderer) renderMinimap(pou elements.POU, x, y, width, height int) Minimap {
+	// Smallest size that still shows on the minimap, a pixel
+	pixel := width/minimapWidth + 1
+	minimap := Minimap{Class: "minimap", Display: "none"}
+	minimap.Rect = append(minimap.Rect, Rect{
+		X:           x,
+		Y:           y,
+		Width:       width,
+		Height:      height,
+		Fill:        r.Theme.Background,
+		FillOpacity: 0.9,
+		Stroke:      r.color(elements.DiffUnchanged),
+		StrokeWidth: pixel,
+		Class:       r.class("background", elements.DiffUnchanged.String()),
+	})
+	for _, rung := range pou.Rungs {
+		if !pou.RungHasChanges(rung) {
+			continue
+		}
+		diff := rungDiff(pou, rung)
+		top, bottom := rung.Top-CELL_SIZE*3, rung.Bottom+changeGlyphHeight
+		minimap.Rect = append(minimap.Rect,
+			Rect{
+				X:           x,
+				Y:           top,
+				Width:       width,
+				Height:      bottom - top,
+				Fill:        r.color(diff),
+				FillOpacity: 0.15,
+				Class:       r.class("marker", diff.String()),
+			},
+			Rect{
+				X:      x,
+				Y:      top,
+				Width:  pixel * 3,
+				Height: bottom - top,
+				Fill:   r.color(diff),
+				Class:  navigationClass("change-marker", r.class("marker", diff.String())),
+			})
+	}
+	for _, elem := range pou.Elements {
+		opacity := float32(1)
+		if elem.Diff == elements.DiffUnchanged {
+			opacity = 0.5
+		}
+		minimap.Rect = append(minimap.Rect, Rect{
+			X:           elem.Position.X,
+			Y:           elem.Position.Y,
+			Width:       max(elem.Width, pixel),
+			Height:      max(elem.Height, pixel),
+			Fill:        r.color(elem.Diff),
+			FillOpacity: opacity,
+			Class:       r.class("marker", elem.Diff.String()),
+		})
+	}
+	// Part of the diagram in view, placed by the script
+	minimap.Rect = append(minimap.Rect, Rect{
+		Fill:        r.color(elements.DiffUnchanged),
+		FillOpacity: 0.2,
+		Stroke:      r.color(elements.DiffUnchanged),
+		StrokeWidth: pixel,
+		Class:       navigationClass("minimap-view", r.class("marker", elements.DiffUnchanged.String())),
+	})
+	return minimap
+}
+
+// Pans the view of a standalone SVG: n and p go to the next and previous changed
+// rung, 0 or Escape back to the whole diagram, the mouse wheel scrolls and
+// clicking the minimap jumps to that spot. Diagrams taller than the window start
+// at the top, fitted to its width. Does nothing when the SVG is inlined into a
+// page, which has navigation of its own.
+const navigationScript = `(function () {
+var svg = document.currentScript && document.currentScript.closest("svg");
+if (!svg || svg !== document.documentElement) return;
+var full = svg.getAttribute("viewBox");
+var numbers = full.split(" ").map(Number);
+var box = {x: numbers[0], y: numbers[1], width: numbers[2], height: numbers[3]};
+var minimap = svg.querySelector(".minimap");
+var frame = minimap.querySelector("rect");
+var shown = minimap.querySelector(".minimap-view");
+var stops = Array.prototype.map.call(minimap.querySelectorAll(".change-marker"), function (marker) {
+	return {y: marker.y.baseVal.value, height: marker.height.baseVal.value};
+});
+var current = -1;
+var top = null;
+function viewHeight(height) { return Math.max(box.width * window.innerHeight / window.innerWidth, height || 0); }
+function place(y, height) {
+	var h = viewHeight(height);
+	var w = h * window.innerWidth / window.innerHeight;
+	top = Math.max(box.y, Math.min(y, box.y + box.height - h));
+	var left = box.x - (w - box.width) / 2;
+	svg.setAttribute("viewBox", [left, top, w, h].join(" "));
+	var perPixel = w / window.innerWidth;
+	var scale = Math.min(%d / box.width, window.innerHeight * 0.6 / box.height);
+	var x0 = left + w - (box.width * scale + 10) * perPixel;
+	var y0 = top + 10 * perPixel;
+	minimap.setAttribute("transform", "translate(" + x0 + " " + y0 + ") scale(" + scale * perPixel + ") translate(" + (-box.x) + " " + (-box.y) + ")");
+	shown.setAttribute("x", box.x);
+	shown.setAttribute("y", top);
+	shown.setAttribute("width", box.width);
+	shown.setAttribute("height", Math.min(h, box.height));
+	minimap.setAttribute("display", "inline");
+}
+function center(y, height) { place(y + height / 2 - viewHeight(height) / 2, height); }
+function reset() {
+	top = null;
+	current = -1;
+	svg.setAttribute("viewBox", full);
+	minimap.setAttribute("display", "none");
+}
+function go(step) {
+	if (stops.length === 0) return;
+	current = current < 0 && step < 0 ? stops.length - 1 : (current + step + stops.length) %% stops.length;
+	center(stops[current].y, stops[current].height);
+}
+document.addEventListener("keydown", function (event) {
+	if (event.ctrlKey || event.metaKey || event.altKey) return;
+	if (event.key === "n") go(1);
+	else if (event.key === "p") go(-1);
+	else if (event.key === "0" || event.key === "Escape") reset();
+});
+svg.addEventListener("wheel", function (event) {
+	if (top === null) return;
+	event.preventDefault();
+	place(top + event.deltaY * svg.viewBox.baseVal.width / window.innerWidth);
+}, {passive: false});
+minimap.addEventListener("click", function (event) {
+	var point = svg.createSVGPoint();
+	point.x = event.clientX;
+	point.y = event.clientY;
+	point = point.matrixTransform(frame.getScreenCTM().inverse());
+	center(point.y, 0);
+});
+window.addEventListener("resize", function () { if (top !== null) place(top); });
+if (box.height > viewHeight()) place(box.y);
+})();`
+
+// Minimap and navigation script for the diagram within the view box
+func (r *Renderer) renderNavigation(pou elements.POU, x, y, width, height int) (Minimap, Script) {
+	return r.renderMinimap(pou, x, y, width, height), Script{Content: fmt.Sprintf(navigationScript, minimapWidth)}
+}
@@ -0,0 +1,94 @@
+package svg
+
+import (
+	"sync"
+
+	elements "openplc-render/elements"
+)
+
+// Renders POUs with its own theme and options. Rendering doesn't touch any
+// package-level state, so renderers with different themes can be used side by
+// side, and a single renderer can be shared between goroutines.
+type Renderer struct {
+	Theme         Theme
+	ChangesOnly   bool     // Fold unchanged rungs away, see RenderFoldedPOU
+	Context       int      // Unchanged rungs to keep around changed ones when folding
+	VirtualWires  bool     // Dashed lines from connectors to their continuations
+	IDPrefix      string   // Prepended to element IDs, keeps them unique with several diagrams on one page
+	LabelFit      LabelFit // How labels too wide for their space are made to fit
+	CompactBlocks bool     // Fold variable boxes into the block pins they're wired to, see elements.POU.Compact
+	Grid          bool     // Grid of OpenPLC editor cells behind the diagram, with rulers
+	DebugIDs      bool     // Local IDs of elements in small print next to them
+	Navigation    bool     // Minimap and keyboard navigation between changed rungs, see renderNavigation
+}
+
+// Returns a renderer with the theme of the given name, dark if there's no such theme
+func NewRenderer(style string) *Renderer {
+	return &Renderer{Theme: GetTheme(style)}
+}
+
+// Renders a POU according to the renderer options
+func (r *Renderer) Render(pou elements.POU, annotations Annotations) SVGFile {
+	if r.ChangesOnly {
+		return r.RenderFoldedPOU(pou, r.Context, annotations)
+	}
+	return r.RenderPOU(pou, annotations)
+}
+
+// Renders the whole POU regardless of the options
+func (r *Renderer) RenderPOU(pou elements.POU, annotations Annotations) SVGFile {
+	if r.CompactBlocks {
+		pou = pou.Compact()
+	}
+	return r.render(pou, annotations, nil)
+}
+
+// Renders only the rungs with changes and context rungs around them, with markers
+// in place of the folded ones. Only meaningful for a POU that has been diffed.
+func (r *Renderer) RenderFoldedPOU(pou elements.POU, context int, annotations Annotations) SVGFile {
+	// Compacted first, so that rungs that only got shorter fold the same way
+	if r.CompactBlocks {
+		pou = pou.Compact()
+	}
+	folded, folds := pou.Fold(context)
+	return r.render(folded, annotations, folds)
+}
+
+// Renders every POU on its own goroutine, the files are in the same order as the POUs
+func (r *Renderer) RenderAll(pous []elements.POU) []SVGFile {
+	files := make([]SVGFile, len(pous))
+	var wg sync.WaitGroup
+	for i := range pous {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			files[i] = r.Render(pous[i], Annotations{})
+		}(i)
+	}
+	wg.Wait()
+	return files
+}
+
+func (r *Renderer) color(diff elements.Diff) string {
+	switch diff {
+	case elements.DiffAdded:
+		return r.Theme.Added
+	case elements.DiffDeleted:
+		return r.Theme.Deleted
+	case elements.DiffModified:
+		return r.Theme.Modified
+	}
+	return r.Theme.Foreground
+}
+
+func (r *Renderer) strokeWidth(diff elements.Diff) int {
+	switch diff {
+	case elements.DiffAdded:
+		return r.Theme.AddedStrokeWidth
+	case elements.DiffDeleted:
+		return r.Theme.DeletedStrokeWidth
+	case elements.DiffModified:
+		return r.Theme.ModifiedStrokeWidth
+	}
+	return r.Theme.StrokeWidth
+}
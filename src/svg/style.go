@@ -0,0 +1,46 @@
+package svg
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strings"
+
+	elements "openplc-render/elements"
+)
+
+// Embedded stylesheet
+type Style struct {
+	XMLName xml.Name `xml:"style"`
+	Content string   `xml:",chardata"`
+}
+
+// Space separated class names for an SVG element, empty unless the theme has a
+// dark counterpart. Outlines are classed by their diff, texts are labels, wires
+// and filled markers have classes of their own.
+func (r *Renderer) class(names ...string) string {
+	if r.Theme.Dark == "" {
+		return ""
+	}
+	return strings.Join(names, " ")
+}
+
+// Stylesheet switching the colors to the dark counterpart of the theme when the
+// viewer prefers a dark color scheme, nil if there's none. The colors of the
+// theme itself stay in the attributes, so viewers without CSS show those.
+func (r *Renderer) style() *Style {
+	if r.Theme.Dark == "" {
+		return nil
+	}
+	dark := GetTheme(r.Theme.Dark)
+	dark_renderer := Renderer{Theme: dark}
+	var rules []string
+	rules = append(rules,
+		fmt.Sprintf(".background, .badge, .inverse { fill: %s; }", dark.Background))
+	for _, diff := range []elements.Diff{elements.DiffUnchanged, elements.DiffAdded, elements.DiffDeleted, elements.DiffModified} {
+		color := dark_renderer.color(diff)
+		rules = append(rules,
+			fmt.Sprintf(".%s { stroke: %s; }", diff, color),
+			fmt.Sprintf(".label.%s, .marker.%s { fill: %s; stroke: none; }", diff, diff, color))
+	}
+	return &Style{Content: "@media (prefers-color-scheme: dark) { " + strings.Join(rules, " ") + " }"}
+}
@@ -6,39 +6,31 @@ package svg
 import (
 	"encoding/xml"
 	"fmt"
+	"math"
 	elements "openplc-render/elements"
 	"strconv"
 )
 
 const CELL_SIZE int = 10
 
-var diff_color = map[elements.Diff]string{
-	elements.DiffAdded:     "green",
-	elements.DiffDeleted:   "red",
-	elements.DiffUnchanged: "white",
-}
-
-var stroke_width = map[elements.Diff]int{
-	elements.DiffAdded:     5,
-	elements.DiffDeleted:   1,
-	elements.DiffUnchanged: 1,
-}
-
 var stroke_dasharray = map[elements.Diff]string{
 	elements.DiffAdded:     "",
 	elements.DiffDeleted:   "4",
+	elements.DiffModified:  "",
 	elements.DiffUnchanged: "",
 }
 
 var text_decoration = map[elements.Diff]string{
 	elements.DiffAdded:     "",
 	elements.DiffDeleted:   "line-through",
+	elements.DiffModified:  "",
 	elements.DiffUnchanged: "",
 }
 
 var font_weight = map[elements.Diff]string{
 	elements.DiffAdded:     "bold",
 	elements.DiffDeleted:   "",
+	elements.DiffModified:  "",
 	elements.DiffUnchanged: "",
 }
 
@@ -46,19 +38,29 @@ type SVGFile struct {
 	XMLName  xml.Name  `xml:"svg"`
 	ViewBox  string    `xml:"viewBox,attr"`
 	Xmlns    string    `xml:"xmlns,attr"`
+	Role     string    `xml:"role,attr,omitempty"`
+	Title    *Title    `xml:"title,omitempty"`
+	Desc     *Desc     `xml:"desc,omitempty"`
+	Metadata *Metadata `xml:"metadata,omitempty"` // Changes in plain text, one per line
+	Style    *Style    `xml:"style,omitempty"`
 	Elements []Element `xml:",any"`
 }
 
 // Background rect
 type Background struct {
-	XMLName xml.Name `xml:"rect"`
-	Width   int      `xml:"width,attr"`
-	Height  int      `xml:"height,attr"`
-	Fill    string   `xml:"fill,attr,omitempty"`
+	XMLName    xml.Name `xml:"rect"`
+	Class      string   `xml:"class,attr,omitempty"`
+	X          int      `xml:"x,attr,omitempty"`
+	Y          int      `xml:"y,attr,omitempty"`
+	Width      int      `xml:"width,attr"`
+	Height     int      `xml:"height,attr"`
+	Fill       string   `xml:"fill,attr,omitempty"`
+	AriaHidden string   `xml:"aria-hidden,attr,omitempty"`
 }
 
 type Rect struct {
 	XMLName         xml.Name `xml:"rect"`
+	Class           string   `xml:"class,attr,omitempty"`
 	Width           int      `xml:"width,attr"`
 	Height          int      `xml:"height,attr"`
 	X               int      `xml:"x,attr"`
@@ -72,27 +74,41 @@ type Rect struct {
 
 type Line struct {
 	XMLName         xml.Name `xml:"line"`
+	Class           string   `xml:"class,attr,omitempty"`
 	X1              int      `xml:"x1,attr"`
 	Y1              int      `xml:"y1,attr"`
 	X2              int      `xml:"x2,attr"`
 	Y2              int      `xml:"y2,attr"`
 	Stroke          string   `xml:"stroke,attr,omitempty"`
 	StrokeWidth     int      `xml:"stroke-width,attr,omitempty"`
+	StrokeOpacity   float32  `xml:"stroke-opacity,attr,omitempty"`
 	StrokeDasharray string   `xml:"stroke-dasharray,attr,omitempty"`
 }
 
 type Polyline struct {
 	XMLName         xml.Name `xml:"polyline"`
+	Class           string   `xml:"class,attr,omitempty"`
 	Points          string   `xml:"points,attr"`
 	Stroke          string   `xml:"stroke,attr,omitempty"`
 	StrokeWidth     int      `xml:"stroke-width,attr,omitempty"`
 	Fill            string   `xml:"fill,attr,omitempty"`
 	FillOpacity     float32  `xml:"fill-opacity,attr,omitempty"`
 	StrokeDasharray string   `xml:"stroke-dasharray,attr,omitempty"`
+	Title           *Title   `xml:"title,omitempty"`
+}
+
+// Tooltip shown when hovering over the parent element
+type Title struct {
+	XMLName xml.Name `xml:"title"`
+	Content string   `xml:",chardata"`
 }
 
 type Group struct {
 	XMLName  xml.Name   `xml:"g"`
+	ID       string     `xml:"id,attr,omitempty"`
+	Role     string     `xml:"role,attr,omitempty"`
+	Title    *Title     `xml:"title,omitempty"`
+	Desc     *Desc      `xml:"desc,omitempty"`
 	Line     []Line     `xml:"line,omitempty"`
 	Rect     []Rect     `xml:"rect,omitempty"`
 	Text     []Text     `xml:"text,omitempty"`
@@ -102,11 +118,12 @@ type Group struct {
 
 type Text struct {
 	XMLName        xml.Name `xml:"text"`
+	Class          string   `xml:"class,attr,omitempty"`
 	X              int      `xml:"x,attr"`
 	Y              int      `xml:"y,attr"`
 	TextAnchor     string   `xml:"text-anchor,attr"`
-	LengthAdjust   string   `xml:"lengthAdjust,attr"`
-	TextLength     string   `xml:"textLength,attr"`
+	LengthAdjust   string   `xml:"lengthAdjust,attr,omitempty"`
+	TextLength     string   `xml:"textLength,attr,omitempty"`
 	TextDecoration string   `xml:"text-decoration,attr,omitempty"`
 	FontFamily     string   `xml:"font-family,attr,omitempty"`
 	FontStyle      string   `xml:"font-style,attr,omitempty"`
@@ -114,11 +131,13 @@ type Text struct {
 	FontWeight     string   `xml:"font-weight,attr,omitempty"`
 	Fill           string   `xml:"fill,attr,omitempty"`
 	FillOpacity    float32  `xml:"fill-opacity,attr,omitempty"`
+	Title          *Title   `xml:"title,omitempty"` // Full content of a truncated label
 	Content        string   `xml:",chardata"`
 }
 
 type Path struct {
 	XMLName         xml.Name `xml:"path"`
+	Class           string   `xml:"class,attr,omitempty"`
 	D               string   `xml:"d,attr"`
 	Stroke          string   `xml:"stroke,attr,omitempty"`
 	StrokeWidth     int      `xml:"stroke-width,attr,omitempty"`
@@ -129,14 +148,33 @@ type Path struct {
 
 type Element interface{}
 
-func renderContact(elem *elements.Element) Group {
+// Extra information layered on top of a rendered POU, like tooltips or highlights
+type Annotation struct {
+	Title     string // Tooltip shown on hover
+	Highlight string // Fill color of a box drawn behind the element, none if empty
+}
+
+type LegendEntry struct {
+	Color string
+	Label string
+}
+
+type Annotations struct {
+	Elements    map[string]Annotation // By element UID
+	Connections map[string]Annotation // By elements.ConnectionID
+	Legend      []LegendEntry         // Rendered below the diagram
+	Header      *Header               // Title block rendered at the very bottom, none if nil
+}
+
+func (r *Renderer) renderContact(elem *elements.Element, label_space int) Group {
 	line_1 := Line{
 		X1:              elem.Position.X,
 		Y1:              elem.Position.Y,
 		X2:              elem.Position.X,
 		Y2:              elem.Position.Y + elem.Height,
-		Stroke:          diff_color[elem.Diff],
-		StrokeWidth:     stroke_width[elem.Diff],
+		Stroke:          r.color(elem.Diff),
+		Class:           r.class(elem.Diff.String()),
+		StrokeWidth:     r.strokeWidth(elem.Diff),
 		StrokeDasharray: stroke_dasharray[elem.Diff],
 	}
 	line_2 := Line{
@@ -144,8 +182,9 @@ func renderContact(elem *elements.Element) Group {
 		Y1:              elem.Position.Y,
 		X2:              elem.Position.X + elem.Width,
 		Y2:              elem.Position.Y + elem.Height,
-		Stroke:          diff_color[elem.Diff],
-		StrokeWidth:     stroke_width[elem.Diff],
+		Stroke:          r.color(elem.Diff),
+		Class:           r.class(elem.Diff.String()),
+		StrokeWidth:     r.strokeWidth(elem.Diff),
 		StrokeDasharray: stroke_dasharray[elem.Diff],
 	}
 	text := Text{
@@ -153,42 +192,36 @@ func renderContact(elem *elements.Element) Group {
 		Y:              elem.Position.Y - CELL_SIZE/2,
 		Content:        elem.TopLabel.Value,
 		TextAnchor:     "middle",
-		FontFamily:     "arial",
-		FontSize:       strconv.Itoa(CELL_SIZE + CELL_SIZE/4),
-		Fill:           diff_color[elem.TopLabel.Diff],
+		FontFamily:     r.Theme.FontFamily,
+		FontSize:       strconv.Itoa(r.Theme.FontSize),
+		Fill:           r.color(elem.TopLabel.Diff),
+		Class:          r.class("label", elem.TopLabel.Diff.String()),
 		TextDecoration: text_decoration[elem.TopLabel.Diff],
 		FontWeight:     font_weight[elem.TopLabel.Diff],
 	}
-	inner_text := Text{
-		X:              elem.Position.X + (elem.Width / 2),
-		Y:              elem.Position.Y + elem.Height/2 + elem.Height/4,
-		Content:        elem.ElementText.Value,
-		TextAnchor:     "middle",
-		FontFamily:     "arial",
-		Fill:           diff_color[elem.ElementText.Diff],
-		TextDecoration: text_decoration[elem.ElementText.Diff],
-		FontWeight:     font_weight[elem.ElementText.Diff],
-	}
+	r.fitText(&text, label_space)
 	return Group{
 		Line: []Line{line_1, line_2},
-		Text: []Text{text, inner_text},
+		Text: append([]Text{text}, r.renderModifiers(elem)...),
 	}
 }
 
-func renderCoil(elem *elements.Element) Group {
+func (r *Renderer) renderCoil(elem *elements.Element, label_space int) Group {
 	curve_left_d := fmt.Sprintf("M %d %d Q %d %d %d %d", elem.Position.X+CELL_SIZE/2, elem.Position.Y, elem.Position.X-CELL_SIZE/2, elem.Position.Y+elem.Height/2, elem.Position.X+CELL_SIZE/2, elem.Position.Y+elem.Height)
 	curve_left := Path{
 		D:               curve_left_d,
-		Stroke:          diff_color[elem.Diff],
-		StrokeWidth:     stroke_width[elem.Diff],
+		Stroke:          r.color(elem.Diff),
+		Class:           r.class(elem.Diff.String()),
+		StrokeWidth:     r.strokeWidth(elem.Diff),
 		StrokeDasharray: stroke_dasharray[elem.Diff],
 		Fill:            "transparent",
 	}
 	curve_right_d := fmt.Sprintf("M %d %d Q %d %d %d %d", elem.Position.X+elem.Width-CELL_SIZE/2, elem.Position.Y, elem.Position.X+elem.Width+CELL_SIZE/2, elem.Position.Y+elem.Height/2, elem.Position.X+elem.Width-CELL_SIZE/2, elem.Position.Y+elem.Height)
 	curve_right := Path{
 		D:               curve_right_d,
-		Stroke:          diff_color[elem.Diff],
-		StrokeWidth:     stroke_width[elem.Diff],
+		Stroke:          r.color(elem.Diff),
+		Class:           r.class(elem.Diff.String()),
+		StrokeWidth:     r.strokeWidth(elem.Diff),
 		StrokeDasharray: stroke_dasharray[elem.Diff],
 		Fill:            "transparent",
 	}
@@ -197,29 +230,54 @@ func renderCoil(elem *elements.Element) Group {
 		Y:              elem.Position.Y - CELL_SIZE/2,
 		Content:        elem.TopLabel.Value,
 		TextAnchor:     "middle",
-		FontFamily:     "arial",
-		FontSize:       strconv.Itoa(CELL_SIZE + CELL_SIZE/4),
-		Fill:           diff_color[elem.TopLabel.Diff],
+		FontFamily:     r.Theme.FontFamily,
+		FontSize:       strconv.Itoa(r.Theme.FontSize),
+		Fill:           r.color(elem.TopLabel.Diff),
+		Class:          r.class("label", elem.TopLabel.Diff.String()),
 		TextDecoration: text_decoration[elem.TopLabel.Diff],
 		FontWeight:     font_weight[elem.TopLabel.Diff],
 	}
-	inner_text := Text{
-		X:              elem.Position.X + (elem.Width / 2),
-		Y:              elem.Position.Y + elem.Height/2 + elem.Height/4,
-		Content:        elem.ElementText.Value,
-		TextAnchor:     "middle",
-		FontFamily:     "arial",
-		Fill:           diff_color[elem.ElementText.Diff],
-		TextDecoration: text_decoration[elem.ElementText.Diff],
-		FontWeight:     font_weight[elem.ElementText.Diff],
-	}
-	return Group{
+	r.fitText(&text, label_space)
+	group := Group{
 		Path: []Path{curve_left, curve_right},
-		Text: []Text{text, inner_text},
+		Text: append([]Text{text}, r.renderModifiers(elem)...),
+	}
+	return group
+}
+
+// IEC symbols of the modifiers of a contact or coil, side by side in the middle
+// of it, each in the style of its own diff
+func (r *Renderer) renderModifiers(elem *elements.Element) []Text {
+	var modifiers []elements.MutableString
+	for _, modifier := range []elements.MutableString{elem.Negated, elem.Storage, elem.Edge} {
+		if modifier.Value != "" {
+			modifiers = append(modifiers, modifier)
+		}
+	}
+	width := 0.0
+	for _, modifier := range modifiers {
+		width += textWidth(modifier.Value, defaultFontSize, font_weight[modifier.Diff] == "bold")
+	}
+	x := float64(elem.Position.X) + float64(elem.Width)/2 - width/2
+	var texts []Text
+	for _, modifier := range modifiers {
+		texts = append(texts, Text{
+			X:              int(math.Round(x)),
+			Y:              elem.Position.Y + elem.Height/2 + elem.Height/4,
+			Content:        modifier.Value,
+			TextAnchor:     "start",
+			FontFamily:     r.Theme.FontFamily,
+			Fill:           r.color(modifier.Diff),
+			Class:          r.class("label", modifier.Diff.String()),
+			TextDecoration: text_decoration[modifier.Diff],
+			FontWeight:     font_weight[modifier.Diff],
+		})
+		x += textWidth(modifier.Value, defaultFontSize, font_weight[modifier.Diff] == "bold")
 	}
+	return texts
 }
 
-func renderConnectorOrContinuation(elem *elements.Element) Group {
+func (r *Renderer) renderConnectorOrContinuation(elem *elements.Element) Group {
 	group := Group{}
 	box := Rect{
 		Width:           elem.Width,
@@ -227,8 +285,9 @@ func renderConnectorOrContinuation(elem *elements.Element) Group {
 		X:               elem.Position.X,
 		Y:               elem.Position.Y,
 		Fill:            "transparent",
-		Stroke:          diff_color[elem.Diff],
-		StrokeWidth:     stroke_width[elem.Diff],
+		Stroke:          r.color(elem.Diff),
+		Class:           r.class(elem.Diff.String()),
+		StrokeWidth:     r.strokeWidth(elem.Diff),
 		StrokeDasharray: stroke_dasharray[elem.Diff],
 	}
 	group.Rect = append(group.Rect, box)
@@ -240,7 +299,8 @@ func renderConnectorOrContinuation(elem *elements.Element) Group {
 	points += fmt.Sprintf("%d,%d ", elem.Position.X, elem.Position.Y+elem.Height)
 	group.Polyline = append(group.Polyline, Polyline{
 		Points:          points,
-		Stroke:          diff_color[elem.Diff],
+		Stroke:          r.color(elem.Diff),
+		Class:           r.class(elem.Diff.String()),
 		StrokeWidth:     1,
 		StrokeDasharray: stroke_dasharray[elem.Diff],
 		Fill:            "transparent",
@@ -252,7 +312,8 @@ func renderConnectorOrContinuation(elem *elements.Element) Group {
 	points += fmt.Sprintf("%d,%d ", elem.Position.X+elem.Width-elem.Height/2, elem.Position.Y+elem.Height)
 	group.Polyline = append(group.Polyline, Polyline{
 		Points:          points,
-		Stroke:          diff_color[elem.Diff],
+		Stroke:          r.color(elem.Diff),
+		Class:           r.class(elem.Diff.String()),
 		StrokeWidth:     1,
 		StrokeDasharray: stroke_dasharray[elem.Diff],
 		Fill:            "transparent",
@@ -263,15 +324,18 @@ func renderConnectorOrContinuation(elem *elements.Element) Group {
 		Y:          elem.Position.Y + CELL_SIZE*2,
 		Content:    elem.ElementText.Value,
 		TextAnchor: "middle",
-		FontFamily: "arial",
-		FontSize:   strconv.Itoa(CELL_SIZE + CELL_SIZE/4),
-		Fill:       diff_color[elem.ElementText.Diff],
+		FontFamily: r.Theme.FontFamily,
+		FontSize:   strconv.Itoa(r.Theme.FontSize),
+		Fill:       r.color(elem.ElementText.Diff),
+		Class:      r.class("label", elem.ElementText.Diff.String()),
 	}
+	// Between the arrows
+	r.fitText(&elem_text, elem.Width-elem.Height)
 	group.Text = append(group.Text, elem_text)
 	return group
 }
 
-func renderVariable(elem *elements.Element) Group {
+func (r *Renderer) renderVariable(elem *elements.Element) Group {
 	group := Group{}
 	box := Rect{
 		Width:           elem.Width,
@@ -279,8 +343,9 @@ func renderVariable(elem *elements.Element) Group {
 		X:               elem.Position.X,
 		Y:               elem.Position.Y,
 		Fill:            "transparent",
-		Stroke:          diff_color[elem.Diff],
-		StrokeWidth:     stroke_width[elem.Diff],
+		Stroke:          r.color(elem.Diff),
+		Class:           r.class(elem.Diff.String()),
+		StrokeWidth:     r.strokeWidth(elem.Diff),
 		StrokeDasharray: stroke_dasharray[elem.Diff],
 	}
 	group.Rect = append(group.Rect, box)
@@ -290,15 +355,17 @@ func renderVariable(elem *elements.Element) Group {
 		Y:          elem.Position.Y + CELL_SIZE*2,
 		Content:    elem.ElementText.Value,
 		TextAnchor: "middle",
-		FontFamily: "arial",
-		FontSize:   strconv.Itoa(CELL_SIZE + CELL_SIZE/4),
-		Fill:       diff_color[elem.ElementText.Diff],
+		FontFamily: r.Theme.FontFamily,
+		FontSize:   strconv.Itoa(r.Theme.FontSize),
+		Fill:       r.color(elem.ElementText.Diff),
+		Class:      r.class("label", elem.ElementText.Diff.String()),
 	}
+	r.fitText(&elem_text, elem.Width-CELL_SIZE/2)
 	group.Text = append(group.Text, elem_text)
 	return group
 }
 
-func renderBlock(elem *elements.Element) Group {
+func (r *Renderer) renderBlock(elem *elements.Element, label_space int) Group {
 	group := Group{}
 	box := Rect{
 		Width:           elem.Width,
@@ -306,8 +373,9 @@ func renderBlock(elem *elements.Element) Group {
 		X:               elem.Position.X,
 		Y:               elem.Position.Y,
 		Fill:            "transparent",
-		Stroke:          diff_color[elem.Diff],
-		StrokeWidth:     stroke_width[elem.Diff],
+		Stroke:          r.color(elem.Diff),
+		Class:           r.class(elem.Diff.String()),
+		StrokeWidth:     r.strokeWidth(elem.Diff),
 		StrokeDasharray: stroke_dasharray[elem.Diff],
 	}
 	group.Rect = append(group.Rect, box)
@@ -316,20 +384,24 @@ func renderBlock(elem *elements.Element) Group {
 		Y:          elem.Position.Y + CELL_SIZE + CELL_SIZE/2,
 		Content:    elem.BlockLabel.Value,
 		TextAnchor: "middle",
-		FontFamily: "arial",
-		FontSize:   strconv.Itoa(CELL_SIZE + CELL_SIZE/4),
-		Fill:       diff_color[elem.BlockLabel.Diff],
+		FontFamily: r.Theme.FontFamily,
+		FontSize:   strconv.Itoa(r.Theme.FontSize),
+		Fill:       r.color(elem.BlockLabel.Diff),
+		Class:      r.class("label", elem.BlockLabel.Diff.String()),
 	}
+	r.fitText(&box_type_text, elem.Width-CELL_SIZE)
 	group.Text = append(group.Text, box_type_text)
 	top_text := Text{
 		X:          elem.Position.X + (elem.Width / 2),
 		Y:          elem.Position.Y - CELL_SIZE/2 - CELL_SIZE/4,
 		Content:    elem.TopLabel.Value,
 		TextAnchor: "middle",
-		FontFamily: "arial",
-		FontSize:   strconv.Itoa(CELL_SIZE + CELL_SIZE/4),
-		Fill:       diff_color[elem.TopLabel.Diff],
+		FontFamily: r.Theme.FontFamily,
+		FontSize:   strconv.Itoa(r.Theme.FontSize),
+		Fill:       r.color(elem.TopLabel.Diff),
+		Class:      r.class("label", elem.TopLabel.Diff.String()),
 	}
+	r.fitText(&top_text, label_space)
 	group.Text = append(group.Text, top_text)
 	// Input pins
 	for _, pin := range elem.Inputs {
@@ -338,11 +410,14 @@ func renderBlock(elem *elements.Element) Group {
 			Y:          elem.Position.Y + pin.Position.Y + CELL_SIZE/2,
 			Content:    pin.Label.Value,
 			TextAnchor: "left",
-			FontFamily: "arial",
-			FontSize:   strconv.Itoa(CELL_SIZE + CELL_SIZE/4),
-			Fill:       diff_color[pin.Label.Diff],
+			FontFamily: r.Theme.FontFamily,
+			FontSize:   strconv.Itoa(r.Theme.FontSize),
+			Fill:       r.color(pin.Label.Diff),
+			Class:      r.class("label", pin.Label.Diff.String()),
 		}
+		r.fitText(&pin_text, pinLabelSpace(elem, pin, elem.Outputs))
 		group.Text = append(group.Text, pin_text)
+		r.renderArgument(elem, pin, ":=", &group)
 	}
 	// Output pins
 	for _, pin := range elem.Outputs {
@@ -351,24 +426,92 @@ func renderBlock(elem *elements.Element) Group {
 			Y:          elem.Position.Y + pin.Position.Y + CELL_SIZE/2,
 			Content:    pin.Label.Value,
 			TextAnchor: "end",
-			FontFamily: "arial",
-			FontSize:   strconv.Itoa(CELL_SIZE + CELL_SIZE/4),
-			Fill:       diff_color[pin.Label.Diff],
+			FontFamily: r.Theme.FontFamily,
+			FontSize:   strconv.Itoa(r.Theme.FontSize),
+			Fill:       r.color(pin.Label.Diff),
+			Class:      r.class("label", pin.Label.Diff.String()),
 		}
+		r.fitText(&pin_text, pinLabelSpace(elem, pin, elem.Inputs))
 		group.Text = append(group.Text, pin_text)
+		r.renderArgument(elem, pin, "=>", &group)
 	}
 	return group
 }
 
-func renderLeftPowerRail(elem *elements.Element) Group {
+// Argument folded into a block pin in compact mode, written like in a call in
+// structured text (PT := T#5s, Q => lamp) outside the block next to the pin
+func (r *Renderer) renderArgument(elem *elements.Element, pin *elements.Pin, assignment string, group *Group) {
+	if pin.Argument.Value == "" {
+		return
+	}
+	position := elem.PinPosition(pin)
+	text := Text{
+		X:              position.X - CELL_SIZE/2,
+		Y:              position.Y - CELL_SIZE/4,
+		Content:        fmt.Sprintf("%s %s %s", pin.Label.Value, assignment, pin.Argument.Value),
+		TextAnchor:     "end",
+		FontFamily:     r.Theme.FontFamily,
+		FontSize:       strconv.Itoa(r.Theme.FontSize),
+		Fill:           r.color(pin.Argument.Diff),
+		Class:          r.class("label", pin.Argument.Diff.String()),
+		TextDecoration: text_decoration[pin.Argument.Diff],
+		FontWeight:     font_weight[pin.Argument.Diff],
+	}
+	if assignment == "=>" {
+		text.X = position.X + CELL_SIZE/2
+		text.TextAnchor = "start"
+	}
+	group.Text = append(group.Text, text)
+}
+
+// Height of the execution order badge including the gap to the element above it
+const executionOrderBadgeHeight = CELL_SIZE*2 - CELL_SIZE/4
+
+// Execution order badge below the bottom right corner, clear of the labels above
+// the element. Nothing if the order isn't set.
+func (r *Renderer) renderExecutionOrder(elem *elements.Element, group *Group) {
+	order := elem.ExecutionOrder
+	if order.Value == "" {
+		return
+	}
+	width := len(order.Value)*CELL_SIZE*3/5 + CELL_SIZE/2
+	x := elem.Position.X + elem.Width - width
+	y := elem.Position.Y + elem.Height + CELL_SIZE/4
+	group.Rect = append(group.Rect, Rect{
+		Width:           width,
+		Height:          executionOrderBadgeHeight - CELL_SIZE/4,
+		X:               x,
+		Y:               y,
+		Fill:            r.Theme.Background,
+		Stroke:          r.color(order.Diff),
+		Class:           r.class("badge", order.Diff.String()),
+		StrokeWidth:     1,
+		StrokeDasharray: stroke_dasharray[order.Diff],
+	})
+	group.Text = append(group.Text, Text{
+		X:              x + width/2,
+		Y:              y + CELL_SIZE + CELL_SIZE/5,
+		Content:        order.Value,
+		TextAnchor:     "middle",
+		FontFamily:     r.Theme.FontFamily,
+		FontSize:       strconv.Itoa(CELL_SIZE),
+		Fill:           r.color(order.Diff),
+		Class:          r.class("label", order.Diff.String()),
+		TextDecoration: text_decoration[order.Diff],
+		FontWeight:     font_weight[order.Diff],
+	})
+}
+
+func (r *Renderer) renderLeftPowerRail(elem *elements.Element) Group {
 	group := Group{}
 	line := Line{
 		X1:          elem.Position.X,
 		Y1:          elem.Position.Y,
 		X2:          elem.Position.X,
 		Y2:          elem.Position.Y + elem.Height,
-		Stroke:      diff_color[elem.Diff],
-		StrokeWidth: 3,
+		Stroke:      r.color(elem.Diff),
+		Class:       r.class(elem.Diff.String()),
+		StrokeWidth: r.Theme.RailStrokeWidth,
 	}
 	group.Line = append(group.Line, line)
 	// Add little stubs for output pins
@@ -378,7 +521,8 @@ func renderLeftPowerRail(elem *elements.Element) Group {
 			Y1:     elem.Position.Y + pin.Position.Y,
 			X2:     elem.Position.X + pin.Position.X,
 			Y2:     elem.Position.Y + pin.Position.Y,
-			Stroke: diff_color[pin.Label.Diff],
+			Stroke: r.color(pin.Label.Diff),
+			Class:  r.class(pin.Label.Diff.String()),
 		}
 		group.Line = append(group.Line, pin_line)
 	}
@@ -386,15 +530,16 @@ func renderLeftPowerRail(elem *elements.Element) Group {
 }
 
 // Pin coordinates are slightly different for the right rail in the XML file, shifted right by one cell width
-func renderRightPowerRail(elem *elements.Element) Group {
+func (r *Renderer) renderRightPowerRail(elem *elements.Element) Group {
 	group := Group{}
 	line := Line{
 		X1:          elem.Position.X,
 		Y1:          elem.Position.Y,
 		X2:          elem.Position.X,
 		Y2:          elem.Position.Y + elem.Height,
-		Stroke:      diff_color[elem.Diff],
-		StrokeWidth: 3,
+		Stroke:      r.color(elem.Diff),
+		Class:       r.class(elem.Diff.String()),
+		StrokeWidth: r.Theme.RailStrokeWidth,
 	}
 	group.Line = append(group.Line, line)
 	// Add little stubs for input pins
@@ -404,14 +549,15 @@ func renderRightPowerRail(elem *elements.Element) Group {
 			Y1:     elem.Position.Y + pin.Position.Y,
 			X2:     elem.Position.X - CELL_SIZE,
 			Y2:     elem.Position.Y + pin.Position.Y,
-			Stroke: diff_color[pin.Label.Diff],
+			Stroke: r.color(pin.Label.Diff),
+			Class:  r.class(pin.Label.Diff.String()),
 		}
 		group.Line = append(group.Line, pin_line)
 	}
 	return group
 }
 
-func renderConnections(elem *elements.Element) Group {
+func (r *Renderer) renderConnections(elem *elements.Element, annotations map[string]Annotation) Group {
 	group := Group{}
 	// Inputs
 	for _, pin := range elem.Inputs {
@@ -422,10 +568,12 @@ func renderConnections(elem *elements.Element) Group {
 			}
 			group.Polyline = append(group.Polyline, Polyline{
 				Points:          points,
-				Stroke:          diff_color[conn.Diff],
-				StrokeWidth:     stroke_width[conn.Diff],
+				Stroke:          r.color(conn.Diff),
+				Class:           r.class("wire", conn.Diff.String()),
+				StrokeWidth:     r.strokeWidth(conn.Diff),
 				StrokeDasharray: stroke_dasharray[conn.Diff],
 				Fill:            "transparent",
+				Title:           connectionTitle(annotations, elements.ConnectionID(elem.UID, true, pin.Order, conn)),
 			})
 		}
 	}
@@ -438,16 +586,94 @@ func renderConnections(elem *elements.Element) Group {
 			}
 			group.Polyline = append(group.Polyline, Polyline{
 				Points:          points,
-				Stroke:          diff_color[conn.Diff],
-				StrokeWidth:     stroke_width[conn.Diff],
+				Stroke:          r.color(conn.Diff),
+				Class:           r.class("wire", conn.Diff.String()),
+				StrokeWidth:     r.strokeWidth(conn.Diff),
 				StrokeDasharray: stroke_dasharray[conn.Diff],
 				Fill:            "transparent",
+				Title:           connectionTitle(annotations, elements.ConnectionID(elem.UID, false, pin.Order, conn)),
 			})
 		}
 	}
 	return group
 }
 
+func connectionTitle(annotations map[string]Annotation, id string) *Title {
+	annotation, ok := annotations[id]
+	if !ok || annotation.Title == "" {
+		return nil
+	}
+	return &Title{Content: annotation.Title}
+}
+
+// Box behind an element covering it together with its top label
+func renderHighlight(elem *elements.Element, color string) Rect {
+	return Rect{
+		Width:       elem.Width + CELL_SIZE,
+		Height:      elem.Height + CELL_SIZE*2 + CELL_SIZE/2,
+		X:           elem.Position.X - CELL_SIZE/2,
+		Y:           elem.Position.Y - CELL_SIZE*2,
+		Fill:        color,
+		FillOpacity: 0.35,
+	}
+}
+
+// Color swatches with labels, one entry per row, starting at the given height
+func (r *Renderer) renderLegend(legend []LegendEntry, y int) Group {
+	group := Group{}
+	for i, entry := range legend {
+		row_y := y + i*CELL_SIZE*2
+		group.Rect = append(group.Rect, Rect{
+			Width:  CELL_SIZE,
+			Height: CELL_SIZE,
+			X:      CELL_SIZE,
+			Y:      row_y,
+			Fill:   entry.Color,
+		})
+		group.Text = append(group.Text, Text{
+			X:          CELL_SIZE * 3,
+			Y:          row_y + CELL_SIZE,
+			Content:    entry.Label,
+			TextAnchor: "start",
+			FontFamily: r.Theme.FontFamily,
+			FontSize:   strconv.Itoa(r.Theme.FontSize),
+			Fill:       r.color(elements.DiffUnchanged),
+			Class:      r.class("label", elements.DiffUnchanged.String()),
+		})
+	}
+	return group
+}
+
+// Rough width of the legend, assuming an average glyph is about 7 pixels wide at this font size
+func legendWidth(legend []LegendEntry) int {
+	width := 0
+	for _, entry := range legend {
+		if w := CELL_SIZE*4 + len(entry.Label)*7; w > width {
+			width = w
+		}
+	}
+	return width
+}
+
+// Rung numbers in the left margin, level with the top of each rung
+func (r *Renderer) renderRungNumbers(pou elements.POU) Group {
+	group := Group{}
+	for _, rung := range pou.Rungs {
+		group.Text = append(group.Text, Text{
+			X:          CELL_SIZE,
+			Y:          rung.Top + CELL_SIZE + CELL_SIZE/2,
+			Content:    strconv.Itoa(rung.Number),
+			TextAnchor: "start",
+			FontFamily: r.Theme.FontFamily,
+			FontSize:   strconv.Itoa(r.Theme.FontSize),
+			FontStyle:  "italic",
+			Fill:       r.color(elements.DiffUnchanged),
+			Class:      r.class("label", elements.DiffUnchanged.String()),
+		})
+	}
+	return group
+}
+
 func calculateViewBox(pou elements.POU) (x, y int) {
 	maxX := 0
 	maxY := 0
@@ -455,8 +681,15 @@ func calculateViewBox(pou elements.POU) (x, y int) {
 		if elem.Position.X+elem.Width > maxX {
 			maxX = elem.Position.X + elem.Width
 		}
-		if elem.Position.Y+elem.Height > maxY {
-			maxY = elem.Position.Y + elem.Height
+		bottom := elem.Position.Y + elem.Height
+		if elem.Diff != elements.DiffUnchanged {
+			bottom += changeGlyphHeight
+		}
+		if elem.ExecutionOrder.Value != "" {
+			bottom = max(bottom, elem.Position.Y+elem.Height+executionOrderBadgeHeight)
+		}
+		if bottom > maxY {
+			maxY = bottom
 		}
 		for _, pin := range elem.Inputs {
 			for _, conn := range pin.Connections {
@@ -486,65 +719,104 @@ func calculateViewBox(pou elements.POU) (x, y int) {
 	return maxX + 10, maxY + 10
 }
 
-func renderBackground(width, height int, style string) Background {
-	var fill string
-	switch style {
-	case "dark":
-		fill = "#0d1117"
-	case "light":
-		fill = "#f6f8fa"
-	}
+func (r *Renderer) renderBackground(x, y, width, height int) Background {
 	return Background{
-		Width:  width,
-		Height: height,
-		Fill:   fill,
+		X:          x,
+		Y:          y,
+		Width:      width,
+		Height:     height,
+		Class:      r.class("background"),
+		Fill:       r.Theme.Background,
+		AriaHidden: "true",
 	}
 }
 
-func setStyle(style string) {
-	switch style {
-	case "dark":
-		diff_color[elements.DiffUnchanged] = "white"
-	case "light":
-		diff_color[elements.DiffUnchanged] = "black"
-	}
+// Renders a POU with the given style, see Renderer for rendering with other options
+func RenderPOU(pou elements.POU, style string) SVGFile {
+	return NewRenderer(style).RenderPOU(pou, Annotations{})
 }
 
-func RenderPOU(pou elements.POU, style string) SVGFile {
+// Same as RenderPOU, with tooltips, highlights and a legend layered on top
+func RenderAnnotatedPOU(pou elements.POU, style string, annotations Annotations) SVGFile {
+	return NewRenderer(style).RenderPOU(pou, annotations)
+}
+
+// Renders only the rungs with changes and context rungs around them, with markers
+// in place of the folded ones. Only meaningful for a POU that has been diffed.
+func RenderFoldedPOU(pou elements.POU, style string, context int, annotations Annotations) SVGFile {
+	return NewRenderer(style).RenderFoldedPOU(pou, context, annotations)
+}
+
+func (r *Renderer) render(pou elements.POU, annotations Annotations, folds []elements.Fold) SVGFile {
 	var file SVGFile
 	// Init SVG file headers and metadata
 	viewX, viewY := calculateViewBox(pou)
-	file.ViewBox = fmt.Sprintf("0 0 %d %d", viewX, viewY)
+	if len(folds) > 0 && folds[len(folds)-1].Y+CELL_SIZE*2 > viewY {
+		viewY = folds[len(folds)-1].Y + CELL_SIZE*2
+	}
+	// Room for the debug IDs of elements at the right edge
+	if r.DebugIDs {
+		viewX += CELL_SIZE * 2
+	}
+	legendY := viewY + CELL_SIZE
+	if len(annotations.Legend) > 0 {
+		viewY = legendY + len(annotations.Legend)*CELL_SIZE*2
+		if w := legendWidth(annotations.Legend); w > viewX {
+			viewX = w
+		}
+	}
+	titleBlockY := viewY + CELL_SIZE
+	if annotations.Header != nil {
+		w, h := titleBlockSize(*annotations.Header)
+		viewY = titleBlockY + h + CELL_SIZE
+		if w+CELL_SIZE*2 > viewX {
+			viewX = w + CELL_SIZE*2
+		}
+	}
+	// Rulers take a margin above and left of the diagram
+	originX, originY := 0, 0
+	if r.Grid {
+		originX, originY = -rulerSize, -rulerSize
+	}
+	file.ViewBox = fmt.Sprintf("%d %d %d %d", originX, originY, viewX-originX, viewY-originY)
 	file.Xmlns = "http://www.w3.org/2000/svg"
+	file.Role = "graphics-document"
+	file.Title = &Title{Content: "Ladder diagram of " + pou.Name}
+	file.Desc = &Desc{Content: summary(pou)}
+	file.Metadata = changeList(pou)
+	file.Style = r.style()
 	// Add background
-	file.Elements = append(file.Elements, renderBackground(viewX, viewY, style))
-	setStyle(style)
+	file.Elements = append(file.Elements, r.renderBackground(originX, originY, viewX-originX, viewY-originY))
+	if r.Grid {
+		file.Elements = append(file.Elements, r.renderGrid(viewX, viewY), r.renderRulers(viewX, viewY))
+	}
+	file.Elements = append(file.Elements, r.renderRungNumbers(pou))
+	links := pou.Links()
+	label_space := labelSpace(pou)
 	// Render elements
 	for _, element := range pou.Elements {
 		//.Printf("ELEM: %v\n", element)
+		annotation := annotations.Elements[element.UID]
+		if annotation.Highlight != "" {
+			file.Elements = append(file.Elements, renderHighlight(element, annotation.Highlight))
+		}
+		var geometry Group
 		switch element.Type {
 		case "contact":
-			geometry := renderContact(element)
-			file.Elements = append(file.Elements, geometry)
+			geometry = r.renderContact(element, label_space[element.UID])
 		case "coil":
-			geometry := renderCoil(element)
-			file.Elements = append(file.Elements, geometry)
+			geometry = r.renderCoil(element, label_space[element.UID])
 		case "connector", "continuation":
-			geometry := renderConnectorOrContinuation(element)
-			file.Elements = append(file.Elements, geometry)
+			geometry = r.renderConnectorOrContinuation(element)
 		case "inOutVariable", "inVariable", "outVariable":
 			fmt.Printf("RENDERING VARIABLE: %s", element.ElementText.Value)
-			geometry := renderVariable(element)
-			file.Elements = append(file.Elements, geometry)
+			geometry = r.renderVariable(element)
 		case "block":
-			geometry := renderBlock(element)
-			file.Elements = append(file.Elements, geometry)
+			geometry = r.renderBlock(element, label_space[element.UID])
 		case "leftPowerRail":
-			geometry := renderLeftPowerRail(element)
-			file.Elements = append(file.Elements, geometry)
+			geometry = r.renderLeftPowerRail(element)
 		case "rightPowerRail":
-			geometry := renderRightPowerRail(element)
-			file.Elements = append(file.Elements, geometry)
+			geometry = r.renderRightPowerRail(element)
 		default:
 			svg_elem := Rect{
 				Width:  element.Width,
@@ -554,11 +826,83 @@ func RenderPOU(pou elements.POU, style string) SVGFile {
 				Fill:   "white",
 				Stroke: "black",
 			}
-			file.Elements = append(file.Elements, svg_elem)
+			geometry.Rect = append(geometry.Rect, svg_elem)
+		}
+		// Any element can be reordered, not only the coils and blocks editors show the order of
+		r.renderExecutionOrder(element, &geometry)
+		geometry.ID = r.elementID(element.UID)
+		geometry.Role = "graphics-symbol"
+		geometry.Desc = elementDesc(pou, element)
+		r.renderChangeGlyph(element, &geometry)
+		if r.DebugIDs {
+			r.renderDebugID(element, &geometry)
+		}
+		title := elementTitle(element)
+		if annotation.Title != "" {
+			title += "\n" + annotation.Title
+		}
+		var partners []string
+		if link, ok := links[element.ElementText.Value]; ok && (element.Type == "connector" || element.Type == "continuation") {
+			partners = link.Partners(element.UID)
+			if link.Dangling() {
+				r.renderDanglingMarker(element, &geometry)
+			}
+			title += "\n" + linkTitle(pou, element, link)
 		}
-		connection_group := renderConnections(element)
+		geometry.Title = &Title{Content: title}
+		// Clicking a connector or continuation jumps to its (first) partner
+		if len(partners) > 0 {
+			file.Elements = append(file.Elements, Anchor{Href: "#" + r.elementID(partners[0]), Group: geometry})
+		} else {
+			file.Elements = append(file.Elements, geometry)
+		}
+		connection_group := r.renderConnections(element, annotations.Connections)
 		file.Elements = append(file.Elements, connection_group)
 	}
+	if r.VirtualWires {
+		file.Elements = append(file.Elements, r.renderVirtualWires(pou, links))
+	}
+	if len(folds) > 0 {
+		file.Elements = append(file.Elements, r.renderFolds(folds, viewX))
+	}
+	if len(annotations.Legend) > 0 {
+		file.Elements = append(file.Elements, r.renderLegend(annotations.Legend, legendY))
+	}
+	if annotations.Header != nil {
+		file.Elements = append(file.Elements, r.renderTitleBlock(*annotations.Header, titleBlockY, viewX-CELL_SIZE*2))
+	}
+	if r.Navigation {
+		minimap, script := r.renderNavigation(pou, originX, originY, viewX-originX, viewY-originY)
+		file.Elements = append(file.Elements, minimap, script)
+	}
 	//fmt.Printf("FILE ELEMENTS: %v\n", file.Elements)
 	return file
 }
+
+func (r *Renderer) renderFolds(folds []elements.Fold, width int) Group {
+	group := Group{}
+	for _, fold := range folds {
+		group.Line = append(group.Line, Line{
+			X1:              CELL_SIZE,
+			Y1:              fold.Y,
+			X2:              width - CELL_SIZE,
+			Y2:              fold.Y,
+			Stroke:          r.color(elements.DiffUnchanged),
+			Class:           r.class(elements.DiffUnchanged.String()),
+			StrokeWidth:     1,
+			StrokeDasharray: "2",
+		})
+		group.Text = append(group.Text, Text{
+			X:          width / 2,
+			Y:          fold.Y - CELL_SIZE/2,
+			Content:    fold.String(),
+			TextAnchor: "middle",
+			FontFamily: r.Theme.FontFamily,
+			FontSize:   strconv.Itoa(r.Theme.FontSize),
+			FontStyle:  "italic",
+			Fill:       r.color(elements.DiffUnchanged),
+			Class:      r.class("label", elements.DiffUnchanged.String()),
+		})
+	}
+	return group
+}
@@ -0,0 +1,216 @@
+package svg
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Colors, fonts and stroke widths of a diagram. Dash patterns, strike-through
+// and bold text are not themeable, since they're what keeps the diff readable
+// without colors.
+type Theme struct {
+	Name    string `json:"name" yaml:"name" toml:"name"`
+	Extends string `json:"extends,omitempty" yaml:"extends,omitempty" toml:"extends,omitempty"` // Built-in theme to take unset fields from
+	Dark    string `json:"dark,omitempty" yaml:"dark,omitempty" toml:"dark,omitempty"`          // Theme to switch to when the viewer prefers a dark color scheme
+
+	Background string `json:"background" yaml:"background" toml:"background"`
+	Foreground string `json:"foreground" yaml:"foreground" toml:"foreground"` // Unchanged elements, text and rails
+	Added      string `json:"added" yaml:"added" toml:"added"`
+	Deleted    string `json:"deleted" yaml:"deleted" toml:"deleted"`
+	Modified   string `json:"modified" yaml:"modified" toml:"modified"`
+
+	FontFamily string `json:"font_family" yaml:"font_family" toml:"font_family"`
+	FontSize   int    `json:"font_size" yaml:"font_size" toml:"font_size"`
+
+	StrokeWidth         int `json:"stroke_width" yaml:"stroke_width" toml:"stroke_width"` // Unchanged elements
+	AddedStrokeWidth    int `json:"added_stroke_width" yaml:"added_stroke_width" toml:"added_stroke_width"`
+	DeletedStrokeWidth  int `json:"deleted_stroke_width" yaml:"deleted_stroke_width" toml:"deleted_stroke_width"`
+	ModifiedStrokeWidth int `json:"modified_stroke_width" yaml:"modified_stroke_width" toml:"modified_stroke_width"`
+	RailStrokeWidth     int `json:"rail_stroke_width" yaml:"rail_stroke_width" toml:"rail_stroke_width"`
+}
+
+var darkTheme = Theme{
+	Name:                "dark",
+	Background:          "#0d1117",
+	Foreground:          "white",
+	Added:               "green",
+	Deleted:             "red",
+	Modified:            "#d29922",
+	FontFamily:          "arial",
+	FontSize:            CELL_SIZE + CELL_SIZE/4,
+	StrokeWidth:         1,
+	AddedStrokeWidth:    5,
+	DeletedStrokeWidth:  1,
+	ModifiedStrokeWidth: 3,
+	RailStrokeWidth:     3,
+}
+
+var lightTheme = extendTheme(darkTheme, Theme{
+	Name:       "light",
+	Background: "#f6f8fa",
+	Foreground: "black",
+})
+
+// Guards themes, loading a theme may race with renderers looking themes up
+var themes_lock sync.RWMutex
+
+// Built-in themes by name. The colorblind-safe palettes avoid the color pairs
+// that are hard to tell apart with the respective kind of color vision deficiency.
+var themes = map[string]Theme{
+	"dark":  darkTheme,
+	"light": lightTheme,
+	// Light, switching to dark with the color scheme of the page the SVG is shown on
+	"auto": extendTheme(lightTheme, Theme{
+		Name: "auto",
+		Dark: "dark",
+	}),
+	"deuteranopia": extendTheme(darkTheme, Theme{
+		Name:       "deuteranopia",
+		Background: "#ffffff",
+		Foreground: "black",
+		Added:      "#0072b2",
+		Deleted:    "#e69f00",
+		Modified:   "#cc79a7",
+	}),
+	"protanopia": extendTheme(darkTheme, Theme{
+		Name:       "protanopia",
+		Background: "#ffffff",
+		Foreground: "black",
+		Added:      "#005ab5",
+		Deleted:    "#dc3220",
+		Modified:   "#8a8a8a",
+	}),
+	"tritanopia": extendTheme(darkTheme, Theme{
+		Name:       "tritanopia",
+		Background: "#ffffff",
+		Foreground: "black",
+		Added:      "#1b7837",
+		Deleted:    "#d81b60",
+		Modified:   "#4d4d4d",
+	}),
+	// High contrast for grayscale printing, changes are told apart by stroke width and dashes
+	"print": {
+		Name:                "print",
+		Background:          "#ffffff",
+		Foreground:          "black",
+		Added:               "black",
+		Deleted:             "#666666",
+		Modified:            "#333333",
+		FontFamily:          "arial",
+		FontSize:            CELL_SIZE + CELL_SIZE/2,
+		StrokeWidth:         1,
+		AddedStrokeWidth:    6,
+		DeletedStrokeWidth:  2,
+		ModifiedStrokeWidth: 3,
+		RailStrokeWidth:     4,
+	},
+}
+
+// Fills the unset fields of a theme from the base one
+func extendTheme(base, theme Theme) Theme {
+	merged := base
+	for _, field := range []struct {
+		dst *string
+		src string
+	}{
+		{&merged.Name, theme.Name},
+		{&merged.Dark, theme.Dark},
+		{&merged.Background, theme.Background},
+		{&merged.Foreground, theme.Foreground},
+		{&merged.Added, theme.Added},
+		{&merged.Deleted, theme.Deleted},
+		{&merged.Modified, theme.Modified},
+		{&merged.FontFamily, theme.FontFamily},
+	} {
+		if field.src != "" {
+			*field.dst = field.src
+		}
+	}
+	for _, field := range []struct {
+		dst *int
+		src int
+	}{
+		{&merged.FontSize, theme.FontSize},
+		{&merged.StrokeWidth, theme.StrokeWidth},
+		{&merged.AddedStrokeWidth, theme.AddedStrokeWidth},
+		{&merged.DeletedStrokeWidth, theme.DeletedStrokeWidth},
+		{&merged.ModifiedStrokeWidth, theme.ModifiedStrokeWidth},
+		{&merged.RailStrokeWidth, theme.RailStrokeWidth},
+	} {
+		if field.src != 0 {
+			*field.dst = field.src
+		}
+	}
+	merged.Extends = ""
+	return merged
+}
+
+// Names of all available themes, built-in and loaded ones
+func ThemeNames() []string {
+	themes_lock.RLock()
+	defer themes_lock.RUnlock()
+	var names []string
+	for name := range themes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Returns the theme with the given name, dark if there's no such theme. Callers
+// taking the name from a user check it against ThemeNames first.
+func GetTheme(name string) Theme {
+	themes_lock.RLock()
+	defer themes_lock.RUnlock()
+	if theme, ok := themes[name]; ok {
+		return theme
+	}
+	return themes["dark"]
+}
+
+// Loads a theme from a JSON, YAML or TOML file (by extension) and makes it available
+// by its name, which defaults to the file name. Unset fields are taken from the theme
+// named in "extends", dark by default.
+func LoadTheme(path string) (Theme, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Theme{}, fmt.Errorf("error: could not read theme file: %w", err)
+	}
+	var theme Theme
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		err = json.Unmarshal(data, &theme)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &theme)
+	case ".toml":
+		err = toml.Unmarshal(data, &theme)
+	default:
+		return Theme{}, fmt.Errorf("error: unsupported theme file format: %s", path)
+	}
+	if err != nil {
+		return Theme{}, fmt.Errorf("error: could not parse theme file %s: %w", path, err)
+	}
+	if theme.Name == "" {
+		theme.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	base := "dark"
+	if theme.Extends != "" {
+		base = theme.Extends
+	}
+	themes_lock.Lock()
+	defer themes_lock.Unlock()
+	if _, ok := themes[base]; !ok {
+		return Theme{}, fmt.Errorf("error: theme %s extends unknown theme %s", theme.Name, base)
+	}
+	theme = extendTheme(themes[base], theme)
+	themes[theme.Name] = theme
+	return theme, nil
+}
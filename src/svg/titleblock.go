@@ -0,0 +1,142 @@
+package svg
+
+import (
+	"strconv"
+
+	elements "openplc-render/elements"
+)
+
+// Where a diagram comes from, rendered as a title block below it so that
+// printed or archived diagrams describe themselves. Empty fields are left out.
+type Header struct {
+	Project        string // ContentHeader name of the project
+	POU            string
+	POUType        string
+	Ref            string // As given by the user, like HEAD~1 or a branch name
+	SHA            string
+	Author         string
+	Date           string
+	ProductVersion string // FileHeader product version
+}
+
+// Label and value rows of the title block
+func (h Header) rows() [][2]string {
+	pou := h.POU
+	if h.POUType != "" {
+		pou += " (" + h.POUType + ")"
+	}
+	version := h.Ref
+	if version == "" {
+		version = h.SHA
+	} else if h.SHA != "" && h.SHA != h.Ref {
+		version += " (" + h.SHA + ")"
+	}
+	var rows [][2]string
+	for _, row := range [][2]string{
+		{"Project", h.Project},
+		{"POU", pou},
+		{"Version", version},
+		{"Author", h.Author},
+		{"Date", h.Date},
+		{"Product version", h.ProductVersion},
+	} {
+		if row[1] != "" {
+			rows = append(rows, row)
+		}
+	}
+	return rows
+}
+
+// Diff styles explained in the title block legend, in display order
+var styleLegend = []struct {
+	diff  elements.Diff
+	label string
+}{
+	{elements.DiffAdded, "added"},
+	{elements.DiffDeleted, "deleted"},
+	{elements.DiffModified, "modified"},
+	{elements.DiffUnchanged, "unchanged"},
+}
+
+// Width of the style legend column, with a line sample in front of every label
+const styleLegendWidth = CELL_SIZE*8 + 9*7
+
+// Rough size of the title block, with the same glyph width estimate as the legend
+func titleBlockSize(header Header) (width, height int) {
+	rows := header.rows()
+	for _, row := range rows {
+		if w := CELL_SIZE*3 + (len(row[0])+2+len(row[1]))*7; w > width {
+			width = w
+		}
+	}
+	count := len(rows)
+	if count < len(styleLegend) {
+		count = len(styleLegend)
+	}
+	return width + styleLegendWidth, count*CELL_SIZE*2 + CELL_SIZE
+}
+
+// Bordered box with the header fields on the left and the diff styles on the right,
+// starting at the given height and spanning the given width
+func (r *Renderer) renderTitleBlock(header Header, y, width int) Group {
+	_, height := titleBlockSize(header)
+	group := Group{}
+	group.Rect = append(group.Rect, Rect{
+		Width:       width,
+		Height:      height,
+		X:           CELL_SIZE,
+		Y:           y,
+		Fill:        "transparent",
+		Stroke:      r.color(elements.DiffUnchanged),
+		Class:       r.class(elements.DiffUnchanged.String()),
+		StrokeWidth: 1,
+	})
+	legendX := CELL_SIZE + width - styleLegendWidth
+	group.Line = append(group.Line, Line{
+		X1:          legendX,
+		Y1:          y,
+		X2:          legendX,
+		Y2:          y + height,
+		Stroke:      r.color(elements.DiffUnchanged),
+		Class:       r.class(elements.DiffUnchanged.String()),
+		StrokeWidth: 1,
+	})
+	for i, row := range header.rows() {
+		group.Text = append(group.Text, Text{
+			X:          CELL_SIZE * 2,
+			Y:          y + (i+1)*CELL_SIZE*2,
+			Content:    row[0] + ": " + row[1],
+			TextAnchor: "start",
+			FontFamily: r.Theme.FontFamily,
+			FontSize:   strconv.Itoa(r.Theme.FontSize),
+			Fill:       r.color(elements.DiffUnchanged),
+			Class:      r.class("label", elements.DiffUnchanged.String()),
+		})
+	}
+	for i, entry := range styleLegend {
+		row_y := y + (i+1)*CELL_SIZE*2
+		group.Line = append(group.Line, Line{
+			X1:              legendX + CELL_SIZE,
+			Y1:              row_y - CELL_SIZE/2,
+			X2:              legendX + CELL_SIZE*5,
+			Y2:              row_y - CELL_SIZE/2,
+			Stroke:          r.color(entry.diff),
+			Class:           r.class(entry.diff.String()),
+			StrokeWidth:     r.strokeWidth(entry.diff),
+			StrokeDasharray: stroke_dasharray[entry.diff],
+		})
+		group.Text = append(group.Text, Text{
+			X:              legendX + CELL_SIZE*6,
+			Y:              row_y,
+			Content:        entry.label,
+			TextAnchor:     "start",
+			TextDecoration: text_decoration[entry.diff],
+			FontFamily:     r.Theme.FontFamily,
+			FontSize:       strconv.Itoa(r.Theme.FontSize),
+			FontWeight:     font_weight[entry.diff],
+			Fill:           r.color(entry.diff),
+			Class:          r.class("label", entry.diff.String()),
+		})
+	}
+	return group
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"sort"
+
+	elements "openplc-render/elements"
+	svg "openplc-render/svg"
+)
+
+// Highlight colors for the three-way change classes
+var change_color = map[elements.Change]string{
+	elements.ChangeOurs:     "#1f77b4",
+	elements.ChangeTheirs:   "#9467bd",
+	elements.ChangeBoth:     "#7f7f7f",
+	elements.ChangeConflict: "#ff7f0e",
+}
+
+// Returns the merge base to diff against: the explicit one if given, or the one
+// computed by git when diffing two branches. Empty means a plain two-way diff.
+func resolveMergeBase(filePath, base string, refs []string) (string, error) {
+	if base != "" {
+		if len(refs) != 2 {
+			return "", fmt.Errorf("error: a merge base needs exactly two refs to diff, got %d", len(refs))
+		}
+		return base, nil
+	}
+	if len(refs) != 2 {
+		return "", nil
+	}
+	absPath, err := filepath.Abs(filePath)
+	if err != nil {
+		return "", err
+	}
+	repoPath, err := getRepoRoot(absPath)
+	if err != nil {
+		return "", err
+	}
+	if !isBranchName(repoPath, refs[0]) || !isBranchName(repoPath, refs[1]) {
+		return "", nil
+	}
+	base, err = getMergeBase(repoPath, refs[0], refs[1])
+	if err != nil {
+		return "", err
+	}
+	log.Printf("merge base of %s and %s: %s", refs[0], refs[1], base)
+	return base, nil
+}
+
+// Renders base, ours and theirs, the latter two diffed against the base and
+// annotated with which side changed each element
+func renderThreeWay(filePath, pouName string, options renderOptions, base, ours, theirs string) ([]svg.SVGFile, error) {
+	// Versions without the POU, like the base of one that got added, are diffed as empty ones
+	var pous [3]elements.POU
+	available := false
+	for i, ref := range []string{base, ours, theirs} {
+		pou, found, err := loadPOUAtRef(filePath, pouName, ref)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			log.Printf("%s: not available at %s, diffing against an empty POU", pouName, ref)
+		}
+		pous[i] = pou
+		available = available || found
+	}
+	if !available {
+		return nil, fmt.Errorf("no POU with name %s available at %s, %s or %s", pouName, base, ours, theirs)
+	}
+	basePou, oursPou, theirsPou := pous[0], pous[1], pous[2]
+	changes := elements.ThreeWayDiff(&basePou, &oursPou, &theirsPou)
+	logChanges(pouName, changes)
+	annotations := changeAnnotations(changes)
+
+	// CalculateDiff marks both sides, so each side gets diffed against its own copy of the base
+	oursBase, _, err := loadPOUAtRef(filePath, pouName, base)
+	if err != nil {
+		return nil, err
+	}
+	theirsBase, _, err := loadPOUAtRef(filePath, pouName, base)
+	if err != nil {
+		return nil, err
+	}
+	oursBase.CalculateDiff(&oursPou)
+	theirsBase.CalculateDiff(&theirsPou)
+	deleted := options.Renderer.Theme.Deleted
+	// The base itself isn't diffed against anything, so there's nothing to fold
+	var files []svg.SVGFile
+	for _, version := range []struct {
+		ref         string
+		pou         elements.POU
+		render      func(elements.POU, svg.Annotations) svg.SVGFile
+		annotations svg.Annotations
+	}{
+		{base, basePou, options.Renderer.RenderPOU, annotations},
+		{ours, oursPou, options.render, withDangling(annotations, &oursBase, &oursPou, deleted)},
+		{theirs, theirsPou, options.render, options.withFindings(withDangling(annotations, &theirsBase, &theirsPou, deleted), pouName)},
+	} {
+		versionAnnotations, err := options.withHeader(version.annotations, filePath, version.ref, version.pou)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, version.render(version.pou, versionAnnotations))
+	}
+	return files, nil
+}
+
+func logChanges(pouName string, changes map[string]elements.Change) {
+	uids := make([]string, 0, len(changes))
+	for uid := range changes {
+		uids = append(uids, uid)
+	}
+	sort.Strings(uids)
+	for _, uid := range uids {
+		log.Printf("%s: element %s: %s", pouName, uid, changes[uid])
+	}
+}
+
+func changeAnnotations(changes map[string]elements.Change) svg.Annotations {
+	annotations := svg.Annotations{
+		Elements: make(map[string]svg.Annotation),
+	}
+	for uid, change := range changes {
+		annotations.Elements[uid] = svg.Annotation{
+			Title:     fmt.Sprintf("Element %s: %s", uid, change),
+			Highlight: change_color[change],
+		}
+	}
+	for _, change := range []elements.Change{elements.ChangeOurs, elements.ChangeTheirs, elements.ChangeBoth, elements.ChangeConflict} {
+		annotations.Legend = append(annotations.Legend, svg.LegendEntry{
+			Color: change_color[change],
+			Label: change.String(),
+		})
+	}
+	return annotations
+}
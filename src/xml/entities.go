@@ -55,9 +55,16 @@ type POU struct {
 }
 
 type Interface struct {
-	LocalVars LocalVars `xml:"localVars"`
+	LocalVars    LocalVars `xml:"localVars"`
+	TempVars     LocalVars `xml:"tempVars"`
+	InputVars    LocalVars `xml:"inputVars"`
+	OutputVars   LocalVars `xml:"outputVars"`
+	InOutVars    LocalVars `xml:"inOutVars"`
+	ExternalVars LocalVars `xml:"externalVars"`
 }
 
+// Variable declarations of a section, like localVars or inputVars. Sections
+// that are repeated, e.g. for constants, are gathered into one.
 type LocalVars struct {
 	Variable []Variable `xml:"variable"`
 }
@@ -67,8 +74,9 @@ type BlockVariables struct {
 }
 
 type Variable struct {
-	Name string  `xml:"name,attr"`
-	Type VarType `xml:"type"`
+	Name    string  `xml:"name,attr"`
+	Address string  `xml:"address,attr,omitempty"` // Like %IX0.0, empty for unlocated variables
+	Type    VarType `xml:"type"`
 }
 
 type BlockVariable struct {
@@ -129,19 +137,21 @@ type Primitive struct {
 	Edge               string            `xml:"edge,attr,omitempty"`
 	Width              int               `xml:"width,attr"`
 	Height             int               `xml:"height,attr"`
+	ExecutionOrderId   int               `xml:"executionOrderId,attr,omitempty"`
 }
 
 type Block struct {
-	ElemType        string
-	LocalId         string         `xml:"localId,attr"`
-	TypeName        string         `xml:"typeName,attr"`
-	InstanceName    string         `xml:"instanceName,attr"`
-	Width           int            `xml:"width,attr"`
-	Height          int            `xml:"height,attr"`
-	Position        Position       `xml:"position"`
-	InputVariables  BlockVariables `xml:"inputVariables"`
-	InOutVariables  BlockVariables `xml:"inOutVariables"`
-	OutputVariables BlockVariables `xml:"outputVariables"`
+	ElemType         string
+	LocalId          string         `xml:"localId,attr"`
+	TypeName         string         `xml:"typeName,attr"`
+	InstanceName     string         `xml:"instanceName,attr"`
+	Width            int            `xml:"width,attr"`
+	Height           int            `xml:"height,attr"`
+	Position         Position       `xml:"position"`
+	InputVariables   BlockVariables `xml:"inputVariables"`
+	InOutVariables   BlockVariables `xml:"inOutVariables"`
+	OutputVariables  BlockVariables `xml:"outputVariables"`
+	ExecutionOrderId int            `xml:"executionOrderId,attr,omitempty"`
 }
 
 type Position struct {
@@ -158,13 +168,15 @@ type Configurations struct {
 }
 
 type Configuration struct {
-	Name     string     `xml:"name,attr"`
-	Resource []Resource `xml:"resource"`
+	Name       string     `xml:"name,attr"`
+	Resource   []Resource `xml:"resource"`
+	GlobalVars LocalVars  `xml:"globalVars"`
 }
 
 type Resource struct {
-	Name string `xml:"name,attr"`
-	Task []Task `xml:"task"`
+	Name       string    `xml:"name,attr"`
+	Task       []Task    `xml:"task"`
+	GlobalVars LocalVars `xml:"globalVars"`
 }
 
 type Task struct {
@@ -188,6 +200,17 @@ func (project *Project) GetPouByName(name string) (pou POU, err error) {
 	return POU{}, fmt.Errorf("no POU with name %s available", name)
 }
 
+// Names of all POUs with a ladder diagram body, in project order
+func (project *Project) GetLDPouNames() []string {
+	var names []string
+	for _, pou := range project.Types.POUs.POU {
+		if len(pou.Body.LD.GatherAllPrimitives()) > 0 || len(pou.Body.LD.Block) > 0 {
+			names = append(names, pou.Name)
+		}
+	}
+	return names
+}
+
 func (ld *LD) ensurePrimitiveTypeLabels() {
 	for _, prim := range ld.Contact {
 		prim.ElemType = "contact"
@@ -0,0 +1,174 @@
+package openplc_xml
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+)
+
+// Raw byte ranges of LD bodies and the elements inside them, for tools that need
+// to rewrite parts of a project file without losing anything the structs above
+// don't model (merging, for example)
+
+// Byte range of a single element inside an LD body
+type Fragment struct {
+	LocalId string
+	Start   int
+	End     int
+}
+
+// Byte range of an <LD> element. Start and End enclose its content, excluding
+// the tags themselves, OuterStart and OuterEnd enclose the element as a whole.
+type LDBody struct {
+	POU        string
+	Start      int
+	End        int
+	OuterStart int
+	OuterEnd   int
+	Fragments  []Fragment
+}
+
+// Byte range of a <connection> element, keyed the same way the diff engine matches connections
+type ConnectionFragment struct {
+	RefLocalId      string
+	FormalParameter string
+	Start           int
+	End             int
+}
+
+// Connections of one connection point (pin) of an element, in document order.
+// Insert is the offset right before the closing tag, where new connections can go,
+// or -1 for self-closing connection points.
+type PinFragment struct {
+	Connections []ConnectionFragment
+	Insert      int
+}
+
+func attrValue(start xml.StartElement, name string) string {
+	for _, attr := range start.Attr {
+		if attr.Name.Local == name {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+// The decoder reports self-closing elements as a start and an end element, this
+// tells whether an end element at the given offset is actually written out
+func isEndTag(data []byte, offset int) bool {
+	return bytes.HasPrefix(data[offset:], []byte("</"))
+}
+
+// Finds the LD bodies of all POUs in a project file
+func ScanLDBodies(data []byte) ([]LDBody, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	var bodies []LDBody
+	var path []string
+	var pou string
+	var body *LDBody
+	var fragmentStart int
+	for {
+		offset := int(decoder.InputOffset())
+		token, err := decoder.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			if t.Name.Local == "pou" {
+				pou = attrValue(t, "name")
+			}
+			depth := len(path)
+			path = append(path, t.Name.Local)
+			if t.Name.Local == "LD" && depth >= 2 && path[depth-1] == "body" && path[depth-2] == "pou" {
+				body = &LDBody{POU: pou, Start: int(decoder.InputOffset()), OuterStart: offset}
+				continue
+			}
+			// Direct children of the LD body are the elements
+			if body != nil && depth >= 1 && path[depth-1] == "LD" {
+				fragmentStart = offset
+				body.Fragments = append(body.Fragments, Fragment{LocalId: attrValue(t, "localId"), Start: offset})
+			}
+		case xml.EndElement:
+			path = path[:len(path)-1]
+			depth := len(path)
+			if body == nil {
+				continue
+			}
+			if t.Name.Local == "LD" && depth >= 2 && path[depth-1] == "body" {
+				body.End = offset
+				body.OuterEnd = int(decoder.InputOffset())
+				if !isEndTag(data, offset) {
+					// Self-closing <LD/>, there's no content
+					body.Start, body.End = body.OuterEnd, body.OuterEnd
+				}
+				bodies = append(bodies, *body)
+				body = nil
+				continue
+			}
+			if depth >= 1 && path[depth-1] == "LD" {
+				last := &body.Fragments[len(body.Fragments)-1]
+				if last.Start == fragmentStart {
+					last.End = int(decoder.InputOffset())
+				}
+			}
+		}
+	}
+	return bodies, nil
+}
+
+// Finds the connection points of an element fragment and the connections in each of them
+func ScanPins(fragment []byte) ([]PinFragment, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(fragment))
+	var pins []PinFragment
+	var pin *PinFragment
+	var conn *ConnectionFragment
+	for {
+		offset := int(decoder.InputOffset())
+		token, err := decoder.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "connectionPointIn", "connectionPointOut":
+				pin = &PinFragment{}
+			case "connection":
+				if pin != nil {
+					conn = &ConnectionFragment{
+						RefLocalId:      attrValue(t, "refLocalId"),
+						FormalParameter: attrValue(t, "formalParameter"),
+						Start:           offset,
+					}
+				}
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "connectionPointIn", "connectionPointOut":
+				if pin != nil {
+					pin.Insert = offset
+					if !isEndTag(fragment, offset) {
+						pin.Insert = -1
+					}
+					pins = append(pins, *pin)
+					pin = nil
+				}
+			case "connection":
+				if conn != nil {
+					conn.End = int(decoder.InputOffset())
+					pin.Connections = append(pin.Connections, *conn)
+					conn = nil
+				}
+			}
+		}
+	}
+	return pins, nil
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	elements "openplc-render/elements"
+	plcxml "openplc-render/xml"
+)
+
+// Usages of a single variable, for the HTML report
+type xrefVariable struct {
+	Name   string
+	Reads  int
+	Writes int
+	Usages []elements.Usage
+}
+
+type xrefPage struct {
+	File      string
+	Refs      []string
+	Diff      bool
+	Added     int
+	Deleted   int
+	Variables []xrefVariable
+}
+
+// One usage in the JSON report
+type xrefEntry struct {
+	Variable string `json:"variable"`
+	POU      string `json:"pou"`
+	Rung     int    `json:"rung,omitempty"`
+	Element  string `json:"element"`
+	Kind     string `json:"kind"`
+	Pin      string `json:"pin,omitempty"`
+	Access   string `json:"access"`
+	Change   string `json:"change,omitempty"`
+}
+
+var xrefTemplate = template.Must(template.New("xref").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Cross-reference of {{.File}}</title>
+<style>
+body { font-family: arial, sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { text-align: left; padding: 0.2em 1em 0.2em 0; }
+tbody { border-top: 1px solid #888; }
+.added { color: #1a7f37; font-weight: bold; }
+.deleted { color: #cf222e; text-decoration: line-through; }
+.summary { color: #555; }
+</style>
+</head>
+<body>
+<h1>Cross-reference</h1>
+<p>{{.File}} at {{range $i, $ref := .Refs}}{{if $i}} compared to {{end}}<code>{{$ref}}</code>{{end}}, {{len .Variables}} variable(s){{if .Diff}}, {{.Added}} usage(s) added, {{.Deleted}} deleted{{end}}</p>
+<table>
+<thead><tr><th>Variable</th><th>POU</th><th>Rung</th><th>Element</th><th>Pin</th><th>Access</th>{{if .Diff}}<th>Change</th>{{end}}</tr></thead>
+{{range .Variables}}
+<tbody>
+<tr><td colspan="{{if $.Diff}}7{{else}}6{{end}}"><strong>{{.Name}}</strong> <span class="summary">{{.Reads}} read(s), {{.Writes}} write(s)</span></td></tr>
+{{range .Usages}}<tr class="{{.Diff}}"><td></td><td>{{.POU}}</td><td>{{if .Rung}}{{.Rung}}{{end}}</td><td>{{.Kind}} #{{.Element}}</td><td>{{.Pin}}</td><td>{{.Access}}</td>{{if $.Diff}}<td>{{if .Diff}}{{.Diff}}{{end}}</td>{{end}}</tr>
+{{end}}
+</tbody>
+{{end}}
+</table>
+</body>
+</html>
+`))
+
+func runXref(args []string) error {
+	flags := flag.NewFlagSet("xref", flag.ExitOnError)
+	filePath := flags.String("file", "", "Path to file inside the git repo")
+	var pouNames refList
+	flags.Var(&pouNames, "pou", "Which POU to cross-reference (repeatable), all POUs with a ladder diagram otherwise")
+	var refs refList
+	flags.Var(&refs, "ref", "Version of the file, or two versions to show the usages added and removed in between (repeatable)")
+	format := flags.String("format", "html", "Output format: html, json or csv")
+	outputFolder := flags.String("output", "", "Folder for the output file, will put it in a system temporary folder otherwise")
+	flags.Parse(args)
+
+	if *filePath == "" {
+		return fmt.Errorf("error: file path not provided")
+	}
+	if len(refs) == 0 {
+		refs = append(refs, "HEAD")
+	}
+	if len(refs) > 2 {
+		return fmt.Errorf("error: at most two refs can be compared")
+	}
+	if *format != "html" && *format != "json" && *format != "csv" {
+		return fmt.Errorf("error: unsupported output format %s", *format)
+	}
+	folder, err := prepareOutputFolder(*outputFolder)
+	if err != nil {
+		return err
+	}
+	log.Printf("output folder path: %s", folder)
+
+	var usages []elements.Usage
+	for i, ref := range refs {
+		ref_usages, err := loadUsages(*filePath, ref, pouNames)
+		if err != nil {
+			return err
+		}
+		if i == 0 {
+			usages = ref_usages
+		} else {
+			usages = elements.DiffUsages(usages, ref_usages)
+		}
+	}
+	path := filepath.Join(folder, "xref."+*format)
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	diff := len(refs) == 2
+	switch *format {
+	case "json":
+		err = writeXrefJSON(f, usages)
+	case "csv":
+		err = writeXrefCSV(f, usages, diff)
+	default:
+		err = xrefTemplate.Execute(f, newXrefPage(*filePath, refs, diff, usages))
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("cross-reference with %d usages written to %s", len(usages), path)
+	return openOutputFolder(folder)
+}
+
+// Usages of variables in the given POUs of a version of the file, or in all of
+// its POUs with a ladder diagram if none are given
+func loadUsages(filePath, ref string, pouNames []string) ([]elements.Usage, error) {
+	pous, err := loadPOUs(filePath, ref, pouNames)
+	if err != nil {
+		return nil, err
+	}
+	var usages []elements.Usage
+	for i := range pous {
+		usages = append(usages, pous[i].Usages()...)
+	}
+	elements.SortUsages(usages)
+	return usages, nil
+}
+
+// Parses the given POUs of a version of the file, or all of its POUs with a
+// ladder diagram if none are given. POUs missing from the version are skipped.
+func loadPOUs(filePath, ref string, pouNames []string) ([]elements.POU, error) {
+	contents, err := getFileContentsFromGit(filePath, ref)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching file contents via git: %w", err)
+	}
+	var project plcxml.Project
+	if err := xml.Unmarshal(contents, &project); err != nil {
+		return nil, fmt.Errorf("error parsing XML: %w", err)
+	}
+	if len(pouNames) == 0 {
+		pouNames = project.GetLDPouNames()
+	}
+	var pous []elements.POU
+	for _, name := range pouNames {
+		pou, err := project.GetPouByName(name)
+		if err != nil {
+			// Compared versions don't need to have the same POUs
+			log.Printf("no POU with name %s available at %s", name, ref)
+			continue
+		}
+		var parsed elements.POU
+		parsed.Parse(pou)
+		pous = append(pous, parsed)
+	}
+	return pous, nil
+}
+
+// Groups the sorted usages by variable
+func newXrefPage(filePath string, refs []string, diff bool, usages []elements.Usage) xrefPage {
+	page := xrefPage{File: filePath, Refs: refs, Diff: diff}
+	for _, usage := range usages {
+		if n := len(page.Variables); n == 0 || page.Variables[n-1].Name != usage.Variable {
+			page.Variables = append(page.Variables, xrefVariable{Name: usage.Variable})
+		}
+		variable := &page.Variables[len(page.Variables)-1]
+		variable.Usages = append(variable.Usages, usage)
+		// Removed usages don't count towards the current reads and writes
+		switch usage.Diff {
+		case elements.DiffAdded:
+			page.Added++
+		case elements.DiffDeleted:
+			page.Deleted++
+			continue
+		}
+		if usage.Access != elements.AccessWrite {
+			variable.Reads++
+		}
+		if usage.Access != elements.AccessRead {
+			variable.Writes++
+		}
+	}
+	return page
+}
+
+// Added or deleted, empty for usages in both versions
+func usageChange(usage elements.Usage) string {
+	if usage.Diff == elements.DiffUnchanged {
+		return ""
+	}
+	return usage.Diff.String()
+}
+
+func writeXrefJSON(w io.Writer, usages []elements.Usage) error {
+	entries := make([]xrefEntry, 0, len(usages))
+	for _, usage := range usages {
+		entries = append(entries, xrefEntry{
+			Variable: usage.Variable,
+			POU:      usage.POU,
+			Rung:     usage.Rung,
+			Element:  usage.Element,
+			Kind:     usage.Kind,
+			Pin:      usage.Pin,
+			Access:   usage.Access.String(),
+			Change:   usageChange(usage),
+		})
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(entries)
+}
+
+func writeXrefCSV(w io.Writer, usages []elements.Usage, diff bool) error {
+	writer := csv.NewWriter(w)
+	header := []string{"variable", "pou", "rung", "element", "kind", "pin", "access"}
+	if diff {
+		header = append(header, "change")
+	}
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+	for _, usage := range usages {
+		rung := ""
+		if usage.Rung != 0 {
+			rung = strconv.Itoa(usage.Rung)
+		}
+		record := []string{usage.Variable, usage.POU, rung, usage.Element, usage.Kind, usage.Pin, usage.Access.String()}
+		if diff {
+			record = append(record, usageChange(usage))
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}